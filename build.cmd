@@ -2,7 +2,8 @@
 @git -C . rev-parse HEAD >temp
 @SET /p GIT_COMMIT= <temp
 
-go build -ldflags="-X main.Version=%VERSION%-%GIT_COMMIT%" -o xml2dat.exe cmd/encoder/main.go
-go build -ldflags="-X main.Version=%VERSION%-%GIT_COMMIT%" -o dat2xml.exe cmd/decoder/main.go
+go build -ldflags="-X main.Version=%VERSION%-%GIT_COMMIT%" -o xml2dat.exe ./cmd/encoder
+go build -ldflags="-X main.Version=%VERSION%-%GIT_COMMIT%" -o dat2xml.exe ./cmd/decoder
+go build -ldflags="-X main.Version=%VERSION%-%GIT_COMMIT%" -o ibx1.exe ./cmd/ibx1
 
 @del /Q temp
@@ -2,26 +2,40 @@ package main
 
 import (
 	"bufio"
-	"encoding/xml"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"juce/fifa-ibx1/data"
 	"os"
 	"path"
+	"sort"
+	"strings"
 )
 
 var Version = "unknown"
 
+var summary data.Summary
+
+// profile is the game profile given with --profile, or nil.
+var profile *data.Profile
+
 func main() {
 	if len(os.Args) < 3 {
 		fmt.Printf("FIFA IBX1 Decoder by juce. Version: %s\n", Version)
 		fmt.Printf("Usage: %s <in-path> <out-path> [options]\n", os.Args[0])
+		fmt.Printf("Use - as <in-path> or <out-path> to read from stdin or write to stdout\n")
 		fmt.Printf("Options:\n")
-		fmt.Printf("\t--debug : print out extra info for troubleshooting\n")
-		fmt.Printf("\t--hex8  : output 8-bit integers in hexadecimal format\n")
-		fmt.Printf("\t--hex16 : output 16-bit integers in hexadecimal format\n")
-		fmt.Printf("\t--hex32 : output 32-bit integers in hexadecimal format\n")
+		fmt.Printf("\t--debug       : print out extra info for troubleshooting\n")
+		fmt.Printf("\t--hex8        : output 8-bit integers in hexadecimal format\n")
+		fmt.Printf("\t--hex16       : output 16-bit integers in hexadecimal format\n")
+		fmt.Printf("\t--hex32       : output 32-bit integers in hexadecimal format\n")
+		fmt.Printf("\t--compact     : write properties as type-prefixed attributes, e.g. blendLength=\"float:0.5\"\n")
+		fmt.Printf("\t--any-flag    : read files with an unknown encoding flag as if it were 0x01 (experimental)\n")
+		fmt.Printf("\t--annotate    : add comments with string/value indices, type ids and byte offsets\n")
+		fmt.Printf("\t--incremental : (directories) skip files whose input has not changed since the last run\n")
+		fmt.Printf("\t--prune       : (with --incremental) delete outputs whose inputs no longer exist\n")
+		fmt.Printf("\t--passthrough=copy|skip|error : what to do with files that are not IBX1 (default: copy)\n")
+		fmt.Printf("\t--profile=<name|file.json> : warn about elements, properties, types and enum values the game profile does not know\n")
 		os.Exit(0)
 	}
 
@@ -29,32 +43,110 @@ func main() {
 	outfile := os.Args[2]
 	options := os.Args[3:]
 
-	fi, err := os.Stat(infile)
+	err := checkPassThrough(options)
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
+	profile, err = loadProfile(options)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if outfile == "-" {
+		stdout = os.Stdout
+		os.Stdout = os.Stderr
+	}
+
+	var fi os.FileInfo
+	if infile != "-" {
+		fi, err = os.Stat(infile)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
 
 	var count int
-	if fi.IsDir() {
+	if fi != nil && fi.IsDir() {
 		// input is a directory
 		count = ProcessDir(infile, outfile, options)
 	} else {
 		// check if output is an existing directory
 		fi, err := os.Stat(outfile)
-		if err == nil && fi.IsDir() {
-			ext := path.Ext(infile)
-			outfile = path.Join(outfile, fmt.Sprintf("%s%s", infile[:len(infile)-len(ext)], ".xml"))
+		if infile != "-" && err == nil && fi.IsDir() {
+			name := path.Base(infile)
+			ext := path.Ext(name)
+			outfile = path.Join(outfile, fmt.Sprintf("%s%s", name[:len(name)-len(ext)], ".xml"))
 		}
 		count = ProcessFile(infile, outfile, options)
 	}
-	if count < 0 {
+	if count >= 0 {
+		fmt.Println("files processed:", count)
+	}
+	summary.Print()
+	if count < 0 || summary.Failed > 0 {
 		os.Exit(1)
 	}
-	fmt.Println("files processed:", count)
+}
+
+func checkPassThrough(opts []string) error {
+	for _, opt := range opts {
+		if strings.HasPrefix(opt, "--passthrough=") {
+			policy := opt[len("--passthrough="):]
+			if policy != data.PassThroughCopy && policy != data.PassThroughSkip && policy != data.PassThroughError {
+				return fmt.Errorf("unknown pass-through policy: %s", policy)
+			}
+		}
+	}
+	return nil
+}
+
+func hasOption(opts []string, name string) bool {
+	for _, opt := range opts {
+		if opt == name {
+			return true
+		}
+	}
+	return false
 }
 
 func ProcessDir(indir string, outdir string, opts []string) int {
+	var manifest *data.Manifest
+	if hasOption(opts, "--incremental") {
+		err := os.MkdirAll(outdir, 0775)
+		if err != nil {
+			fmt.Printf("problem creating output directory: %v\n", err)
+			return -1
+		}
+		manifest, err = data.LoadManifest(outdir, Version, manifestOptions(opts))
+		if err != nil {
+			fmt.Printf("problem reading manifest: %v\n", err)
+			return -1
+		}
+	}
+	count := processDir(indir, outdir, "", opts, manifest)
+	if manifest != nil {
+		if hasOption(opts, "--prune") {
+			removed, err := manifest.Prune()
+			for _, name := range removed {
+				fmt.Printf("removed %s (input deleted)\n", name)
+			}
+			if err != nil {
+				fmt.Printf("problem removing stale output: %v\n", err)
+			}
+		}
+		err := manifest.Save()
+		if err != nil {
+			fmt.Printf("problem writing manifest: %v\n", err)
+			return -1
+		}
+	}
+	return count
+}
+
+func processDir(indir string, outdir string, rel string, opts []string, manifest *data.Manifest) int {
 	count := 0
 	entries, err := ioutil.ReadDir(indir)
 	if err != nil {
@@ -67,26 +159,63 @@ func ProcessDir(indir string, outdir string, opts []string) int {
 		return -1
 	}
 	for _, entry := range entries {
-		if entry.Name() == "." || entry.Name() == ".." {
+		if entry.Name() == "." || entry.Name() == ".." || entry.Name() == data.ManifestName {
 			continue
 		}
 		inItem := path.Join(indir, entry.Name())
 		outItem := path.Join(outdir, entry.Name())
+		relItem := path.Join(rel, entry.Name())
 		if entry.IsDir() {
-			count += ProcessDir(inItem, outItem, opts)
+			count += processDir(inItem, outItem, relItem, opts, manifest)
 		} else {
 			ext := path.Ext(outItem)
 			outItem = fmt.Sprintf("%s%s", outItem[:len(outItem)-len(ext)], ".xml")
-			count += ProcessFile(inItem, outItem, opts)
+			if manifest == nil {
+				count += ProcessFile(inItem, outItem, opts)
+				continue
+			}
+			if manifest.UpToDate(relItem, inItem, outItem) {
+				fmt.Printf("skipping %s (unchanged)\n", inItem)
+				continue
+			}
+			n := ProcessFile(inItem, outItem, opts)
+			if n <= 0 {
+				manifest.Forget(relItem)
+			} else if err := manifest.Record(relItem, inItem, outItem); err != nil {
+				fmt.Printf("problem updating manifest: %v\n", err)
+			}
+			count += n
 		}
 	}
 	return count
 }
 
+// manifestOptions returns the options that affect the produced output,
+// so that changing any of them invalidates the manifest.
+func manifestOptions(opts []string) string {
+	var result []string
+	for _, opt := range opts {
+		if opt == "--debug" || opt == "--incremental" || opt == "--prune" {
+			continue
+		}
+		result = append(result, opt)
+	}
+	sort.Strings(result)
+	return strings.Join(result, " ")
+}
+
 func ProcessFile(infile string, outfile string, opts []string) int {
+	n := processFile(infile, outfile, opts)
+	if n < 0 {
+		summary.Failed++
+	}
+	return n
+}
+
+func processFile(infile string, outfile string, opts []string) int {
 	fmt.Printf("converting %s --> %s ... ", infile, outfile)
 
-	var options data.Options
+	options := data.Options{PassThrough: data.PassThroughCopy}
 	for _, opt := range opts {
 		if opt == "--hex8" {
 			options.Hex8 = true
@@ -96,141 +225,135 @@ func ProcessFile(infile string, outfile string, opts []string) int {
 			options.Hex32 = true
 		} else if opt == "--debug" {
 			options.Debug = true
+		} else if opt == "--annotate" {
+			options.Annotate = true
+		} else if opt == "--compact" {
+			options.Compact = true
+		} else if opt == "--any-flag" {
+			options.AnyEncodingFlag = true
+		} else if strings.HasPrefix(opt, "--passthrough=") {
+			options.PassThrough = opt[len("--passthrough="):]
 		}
 	}
 
-	f, err := os.Open(infile)
+	f, err := openInput(infile)
 	if err != nil {
 		fmt.Printf("%v\n", err)
 		return -1
 	}
 	defer f.Close()
 
-	doc := data.Document{}
-
 	reader := bufio.NewReader(f)
 
-	sig := make([]byte, 4)
-	_, err = io.ReadFull(reader, sig)
-	if err != nil {
-		fmt.Printf("reading signature: %v\n", err)
-		return -1
+	head, _ := reader.Peek(512)
+	typ := data.DetectFileType(head)
+	if typ == data.FileXML {
+		typ = data.FilePlainXML
 	}
-	if string(sig) != "IBX1" {
-		// copy all data unmodified
-		outf, err := os.Create(outfile)
-		if err != nil {
-			fmt.Printf("%v\n", err)
-			return -1
-		}
-		defer outf.Close()
-
-		outf.Write(sig)
-		chunk := make([]byte, 16384)
-		for {
-			n, err := io.ReadFull(reader, chunk)
-			outf.Write(chunk[:n])
-			if err == io.EOF {
-				break
-			}
-		}
-		fmt.Println("OK (unchanged)")
-		return 1
+	if typ != data.FileIBX1 {
+		return passThrough(reader, infile, outfile, typ, &options)
 	}
 
-	// seems to be an IBX1 file
-	// num strings
-	numStrings, err := data.ReadNumber(reader)
+	doc, err := data.ReadDocument(reader, &options)
 	if err != nil {
-		fmt.Printf("reading number of strings: %v\n", err)
+		fmt.Printf("%v\n", err)
 		return -1
 	}
-	if options.Debug {
-		fmt.Printf("number of strings: 0x%x (%d)\n", numStrings.Value, numStrings.Value)
-	}
-	// strings
-	for i := 0; i < numStrings.Value; i++ {
-		n, err := data.ReadNumber(reader)
-		if err != nil {
-			fmt.Printf("reading string length: %v\n", err)
-			return -1
-		}
-		bs := make([]byte, n.Value)
-		_, err = io.ReadFull(reader, bs)
-		if err != nil {
-			fmt.Printf("reading string: %v\n", err)
-			return -1
-		}
-		_, err = reader.ReadByte() // 0-terminator
-		if err != nil {
-			fmt.Printf("reading string 0-terminator: %v\n", err)
-			return -1
-		}
 
-		if options.Debug {
-			fmt.Printf("0x%x (%d): 0x%x {%s}\n", i, i, n.Value, string(bs))
-		}
-		doc.Strings = append(doc.Strings, string(bs))
-	}
-	// num typed values
-	numTypedValues, err := data.ReadNumber(reader)
+	// output as XML
+	outf, err := createOutput(outfile)
 	if err != nil {
-		fmt.Errorf("reading number of typed values: %v\n", err)
+		fmt.Printf("%v\n", err)
 		return -1
 	}
-	if options.Debug {
-		fmt.Printf("number of typed values: 0x%x (%d)\n", numTypedValues.Value, numTypedValues.Value)
+	defer outf.Close()
+
+	err = doc.WriteXML(outf, &options)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return -1
 	}
-	// typed values
-	for i := 0; i < numTypedValues.Value; i++ {
-		tv, err := data.ReadTypedValue(reader)
-		if err != nil {
-			fmt.Printf("reading typed value: %v\n", err)
-			return -1
-		}
+	fmt.Println("OK")
+	checkProfile(doc)
+	summary.Converted++
+	return 1
+}
 
-		if options.Debug {
-			fmt.Printf("0x%x (%d): %v\n", i, i, tv)
+// loadProfile loads the profile named by --profile, if any.
+func loadProfile(opts []string) (*data.Profile, error) {
+	for _, opt := range opts {
+		if strings.HasPrefix(opt, "--profile=") {
+			return data.LoadProfile(opt[len("--profile="):])
 		}
-		doc.TypedValues = append(doc.TypedValues, tv)
 	}
-	// encoding flag
-	_, err = reader.ReadByte()
-	if err != nil {
-		fmt.Printf("reading encoding flag: %v\n", err)
-		return -1
+	return nil, nil
+}
+
+// checkProfile prints what the profile doesn't know about doc.
+func checkProfile(doc *data.Document) {
+	if profile == nil {
+		return
 	}
-	// node structure
-	node, err := data.ReadNode(reader)
-	if err != nil {
-		fmt.Printf("reading node structure: %v\n", err)
-		return -1
+	for _, f := range profile.Check(doc) {
+		fmt.Printf("  %v\n", f)
 	}
-	doc.Element = node
+}
 
-	if options.Debug {
-		fmt.Printf("%v\n", doc)
+// passThrough applies the pass-through policy to a file that is not in
+// IBX1 format.
+func passThrough(reader io.Reader, infile string, outfile string, typ data.FileType, options *data.Options) int {
+	switch options.PassThrough {
+	case data.PassThroughSkip:
+		fmt.Printf("skipped (%v)\n", typ)
+		summary.AddSkipped(typ, infile)
+		return 0
+	case data.PassThroughError:
+		fmt.Printf("not an IBX1 file (%v)\n", typ)
+		return -1
 	}
 
-	// output as XML
-	outf, err := os.Create(outfile)
+	// copy all data unmodified
+	outf, err := createOutput(outfile)
 	if err != nil {
 		fmt.Printf("%v\n", err)
 		return -1
 	}
 	defer outf.Close()
 
-	outf.Write([]byte("<?xml version=\"1.0\" ?>\n"))
-	writer := bufio.NewWriter(outf)
-
-	enc := xml.NewEncoder(writer)
-	enc.Indent("", "  ")
-	err = doc.WriteNode(enc, doc.Element, &options)
+	_, err = io.Copy(outf, reader)
 	if err != nil {
 		fmt.Printf("%v\n", err)
 		return -1
 	}
-	writer.Flush()
-	fmt.Println("OK")
+	fmt.Printf("OK (unchanged, %v)\n", typ)
+	summary.AddCopied(typ, infile)
 	return 1
 }
+
+// stdout is where "-" output goes. When writing to it, os.Stdout is
+// pointed at stderr so that progress messages don't mix with the data.
+var stdout io.Writer = os.Stdout
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// openInput opens the named file for reading, or stdin for "-".
+func openInput(name string) (io.ReadCloser, error) {
+	if name == "-" {
+		return ioutil.NopCloser(os.Stdin), nil
+	}
+	return os.Open(name)
+}
+
+// createOutput creates the named file, or returns stdout for "-".
+func createOutput(name string) (io.WriteCloser, error) {
+	if name == "-" {
+		return nopWriteCloser{stdout}, nil
+	}
+	return os.Create(name)
+}
@@ -1,68 +1,205 @@
 package main
 
 import (
-	"bufio"
-	"encoding/xml"
+	"bytes"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"juce/fifa-ibx1/data"
 	"os"
 	"path"
+	"sort"
+	"strings"
 )
 
 var Version = "unknown"
 
-type PropList struct {
-	props []XmlProp
-}
+var summary data.Summary
 
-type XmlProp struct {
-	name  string
-	typ   string
-	value string
-}
+// profile is the game profile given with --profile, or nil.
+var profile *data.Profile
 
 func main() {
-	if len(os.Args) < 3 {
+	var args []string
+	var options []string
+	for i := 1; i < len(os.Args); i++ {
+		arg := os.Args[i]
+		if arg == "-D" && i+1 < len(os.Args) {
+			i++
+			options = append(options, "--define="+os.Args[i])
+		} else if strings.HasPrefix(arg, "-D") {
+			options = append(options, "--define="+arg[2:])
+		} else if strings.HasPrefix(arg, "--") {
+			options = append(options, arg)
+		} else {
+			args = append(args, arg)
+		}
+	}
+
+	if len(args) < 2 {
 		fmt.Printf("FIFA IBX1 Encoder by juce. Version: %s\n", Version)
 		fmt.Printf("Usage: %s <in-path> <out-path> [options]\n", os.Args[0])
+		fmt.Printf("Use - as <in-path> or <out-path> to read from stdin or write to stdout\n")
 		fmt.Printf("Options:\n")
-		fmt.Printf("\t--debug   : print out extra info for troubleshooting\n")
-		fmt.Printf("\t--noshare : do not re-use typed values (produces larger IBX1 files)\n")
+		fmt.Printf("\t--debug       : print out extra info for troubleshooting\n")
+		fmt.Printf("\t--noshare     : do not re-use typed values (produces larger IBX1 files)\n")
+		fmt.Printf("\t--any-flag    : accept an unknown encoding flag in <?ibx1?> and write it unchanged\n")
+		fmt.Printf("\t--incremental : (directories) skip files whose input has not changed since the last run\n")
+		fmt.Printf("\t--prune       : (with --incremental) delete outputs whose inputs no longer exist\n")
+		fmt.Printf("\t--passthrough=copy|skip|error : what to do with files that are not in the IBX1 XML dialect (default: copy)\n")
+		fmt.Printf("\t--profile=<name|file.json> : warn about elements, properties, types and enum values the game profile does not know\n")
+		fmt.Printf("\t--watch       : keep running and re-encode XML files in <in-path> when they change\n")
+		fmt.Printf("\t--copyto=<dir>: (with --watch) also copy each re-encoded file into <dir>\n")
+		fmt.Printf("\t-D <name>=<value> : value of ${name} in property values (can be repeated); without -D or --vars, ${name} is not expanded\n")
+		fmt.Printf("\t--vars=<file> : read variables from <file>; with [variant] sections, encode each variant into <out-path>/<variant>\n")
 		os.Exit(0)
 	}
 
-	infile := os.Args[1]
-	outfile := os.Args[2]
-	options := os.Args[3:]
+	infile := args[0]
+	outfile := args[1]
+
+	err := checkPassThrough(options)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	profile, err = loadProfile(options)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if outfile == "-" {
+		stdout = os.Stdout
+		os.Stdout = os.Stderr
+	}
+
+	var fi os.FileInfo
+	if infile != "-" {
+		fi, err = os.Stat(infile)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
 
-	fi, err := os.Stat(infile)
+	variants, err := loadVariants(options)
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
+	if len(variants) > 1 && (outfile == "-" || hasOption(options, "--watch")) {
+		fmt.Println("variant sections need <out-path> to be a directory, and do not work with --watch")
+		os.Exit(1)
+	}
 
 	var count int
-	if fi.IsDir() {
-		// input is a directory
-		count = ProcessDir(infile, outfile, options)
-	} else {
-		// check if output is an existing directory
-		fi, err := os.Stat(outfile)
-		if err == nil && fi.IsDir() {
-			ext := path.Ext(infile)
-			outfile = path.Join(outfile, fmt.Sprintf("%s%s", infile[:len(infile)-len(ext)], ".dat"))
+	for _, v := range variants {
+		out := outfile
+		if v.name != "" {
+			fmt.Printf("variant %s:\n", v.name)
+			out = path.Join(outfile, v.name)
+			err = os.MkdirAll(out, 0775)
+			if err != nil {
+				fmt.Printf("problem creating output directory: %v\n", err)
+				os.Exit(1)
+			}
 		}
-		count = ProcessFile(infile, outfile, options)
+		n := process(infile, out, fi, v.opts)
+		if n < 0 {
+			count = -1
+			break
+		}
+		count += n
+	}
+	if count >= 0 {
+		fmt.Println("files processed:", count)
 	}
-	if count < 0 {
+	summary.Print()
+	if count < 0 || summary.Failed > 0 {
 		os.Exit(1)
 	}
-	fmt.Println("files processed:", count)
+}
+
+// process converts infile, a file or directory, into outfile.
+func process(infile string, outfile string, fi os.FileInfo, options []string) int {
+	if hasOption(options, "--watch") {
+		if fi == nil || !fi.IsDir() {
+			fmt.Println("--watch requires <in-path> to be a directory")
+			os.Exit(1)
+		}
+		Watch(infile, outfile, options)
+	} else if fi != nil && fi.IsDir() {
+		// input is a directory
+		return ProcessDir(infile, outfile, options)
+	}
+	// check if output is an existing directory
+	ofi, err := os.Stat(outfile)
+	if infile != "-" && err == nil && ofi.IsDir() {
+		name := path.Base(infile)
+		ext := path.Ext(name)
+		outfile = path.Join(outfile, fmt.Sprintf("%s%s", name[:len(name)-len(ext)], ".dat"))
+	}
+	n, _ := ProcessFile(infile, outfile, options)
+	return n
+}
+
+func checkPassThrough(opts []string) error {
+	for _, opt := range opts {
+		if strings.HasPrefix(opt, "--passthrough=") {
+			policy := opt[len("--passthrough="):]
+			if policy != data.PassThroughCopy && policy != data.PassThroughSkip && policy != data.PassThroughError {
+				return fmt.Errorf("unknown pass-through policy: %s", policy)
+			}
+		}
+	}
+	return nil
+}
+
+func hasOption(opts []string, name string) bool {
+	for _, opt := range opts {
+		if opt == name {
+			return true
+		}
+	}
+	return false
 }
 
 func ProcessDir(indir string, outdir string, opts []string) int {
+	var manifest *data.Manifest
+	if hasOption(opts, "--incremental") {
+		err := os.MkdirAll(outdir, 0775)
+		if err != nil {
+			fmt.Printf("problem creating output directory: %v\n", err)
+			return -1
+		}
+		manifest, err = data.LoadManifest(outdir, Version, manifestOptions(opts))
+		if err != nil {
+			fmt.Printf("problem reading manifest: %v\n", err)
+			return -1
+		}
+	}
+	count := processDir(indir, outdir, "", opts, manifest)
+	if manifest != nil {
+		if hasOption(opts, "--prune") {
+			removed, err := manifest.Prune()
+			for _, name := range removed {
+				fmt.Printf("removed %s (input deleted)\n", name)
+			}
+			if err != nil {
+				fmt.Printf("problem removing stale output: %v\n", err)
+			}
+		}
+		err := manifest.Save()
+		if err != nil {
+			fmt.Printf("problem writing manifest: %v\n", err)
+			return -1
+		}
+	}
+	return count
+}
+
+func processDir(indir string, outdir string, rel string, opts []string, manifest *data.Manifest) int {
 	count := 0
 	entries, err := ioutil.ReadDir(indir)
 	if err != nil {
@@ -75,139 +212,119 @@ func ProcessDir(indir string, outdir string, opts []string) int {
 		return -1
 	}
 	for _, entry := range entries {
-		if entry.Name() == "." || entry.Name() == ".." {
+		if entry.Name() == "." || entry.Name() == ".." || entry.Name() == data.ManifestName {
 			continue
 		}
 		inItem := path.Join(indir, entry.Name())
 		outItem := path.Join(outdir, entry.Name())
+		relItem := path.Join(rel, entry.Name())
 		if entry.IsDir() {
-			count += ProcessDir(inItem, outItem, opts)
+			count += processDir(inItem, outItem, relItem, opts, manifest)
 		} else {
 			ext := path.Ext(outItem)
 			outItem = fmt.Sprintf("%s%s", outItem[:len(outItem)-len(ext)], ".dat")
-			count += ProcessFile(inItem, outItem, opts)
+			if manifest == nil {
+				n, _ := ProcessFile(inItem, outItem, opts)
+				count += n
+				continue
+			}
+			if manifest.UpToDate(relItem, inItem, outItem) {
+				fmt.Printf("skipping %s (unchanged)\n", inItem)
+				continue
+			}
+			n, included := ProcessFile(inItem, outItem, opts)
+			if n <= 0 {
+				// failed, or skipped: there is no output to record
+				manifest.Forget(relItem)
+			} else if err := manifest.Record(relItem, inItem, outItem, included...); err != nil {
+				fmt.Printf("problem updating manifest: %v\n", err)
+			}
+			count += n
 		}
 	}
 	return count
 }
 
-func ProcessFile(infile string, outfile string, opts []string) int {
+// manifestOptions returns the options that affect the produced output,
+// so that changing any of them invalidates the manifest.
+func manifestOptions(opts []string) string {
+	var result []string
+	for _, opt := range opts {
+		if opt == "--debug" || opt == "--incremental" || opt == "--prune" {
+			continue
+		}
+		result = append(result, opt)
+	}
+	sort.Strings(result)
+	return strings.Join(result, " ")
+}
+
+// ProcessFile converts one file. It returns 1 if the file was converted
+// or passed through, 0 if it was skipped and -1 on failure, and the files
+// the input included.
+func ProcessFile(infile string, outfile string, opts []string) (int, []string) {
+	n, included := processFile(infile, outfile, opts)
+	if n < 0 {
+		summary.Failed++
+	}
+	return n, included
+}
+
+func processFile(infile string, outfile string, opts []string) (int, []string) {
 	fmt.Printf("converting %s --> %s ... ", infile, outfile)
 
-	var options data.Options
+	options := data.Options{PassThrough: data.PassThroughCopy}
 	for _, opt := range opts {
 		if opt == "--debug" {
 			options.Debug = true
 		} else if opt == "--noshare" {
 			options.NoShare = true
+		} else if opt == "--any-flag" {
+			options.AnyEncodingFlag = true
+		} else if strings.HasPrefix(opt, "--passthrough=") {
+			options.PassThrough = opt[len("--passthrough="):]
+		} else if strings.HasPrefix(opt, "--define=") {
+			// later definitions override earlier ones
+			name, value := splitDefinition(opt[len("--define="):])
+			if options.Variables == nil {
+				options.Variables = make(map[string]string)
+			}
+			options.Variables[name] = value
 		}
 	}
 
-	f, err := os.Open(infile)
+	f, err := openInput(infile)
 	if err != nil {
-		fmt.Errorf("opening input file: %v", err)
-		return -1
+		fmt.Printf("opening input file: %v\n", err)
+		return -1, nil
 	}
 	defer f.Close()
 
-	doc := data.Document{ShareTypedValues: !options.NoShare}
-
-	dec := xml.NewDecoder(bufio.NewReader(f))
-
-	var stack []*data.Node
-	var propStack []*PropList
-
-	for {
-		tok, err := dec.Token()
-		if err == io.EOF {
-			break
-		} else if err != nil {
-			fmt.Fprintf(os.Stderr, "%v\n", err)
-			return -1
-		}
-		stop := false
-		switch tok := tok.(type) {
-		case xml.StartElement:
-			//fmt.Printf("%s\n", strings.Join(stack, " "))
-			if tok.Name.Local == "property" {
-				x := XmlProp{}
-				for _, a := range tok.Attr {
-					//fmt.Printf("%#v = %#v\n", a.Name.Local, a.Value)
-					if a.Name.Local == "name" {
-						x.name = string(a.Value)
-					} else if a.Name.Local == "type" {
-						x.typ = a.Value
-					} else if a.Name.Local == "value" {
-						x.value = a.Value
-					}
-				}
-				li := propStack[len(propStack)-1]
-				li.props = append(li.props, x)
-			} else {
-				// element
-				if len(tok.Attr) > 0 {
-					fmt.Printf("warn: element %s has attributes ", tok.Name.Local)
-					stop = true
-					break
-				}
-				elem := &data.Node{Name: doc.GetString(tok.Name.Local)}
-				elem.Properties = []*data.Property{}
-				elem.Children = []*data.Node{}
-				stack = append(stack, elem)                //push
-				propStack = append(propStack, &PropList{}) //push
-			}
-		case xml.EndElement:
-			if tok.Name.Local != "property" {
-				elem := stack[len(stack)-1]
-				//fmt.Printf("elem ending: %v\n", *elem.Name)
-				stack = stack[:len(stack)-1] //pop
-				// add props
-				li := propStack[len(propStack)-1]
-				propStack = propStack[:len(propStack)-1] //pop
-				for _, x := range li.props {
-					p := &data.Property{
-						Name:  doc.GetString(x.name),
-						Value: doc.GetTypedValue(x.typ, x.value),
-					}
-					elem.Properties = append(elem.Properties, p)
-				}
-				// add element to parent element, if parent exists
-				if len(stack) > 0 {
-					parent := stack[len(stack)-1]
-					parent.Children = append(parent.Children, elem)
-				} else {
-					// done: assign
-					doc.Element = elem
-				}
-			}
-		}
-		if stop {
-			break
-		}
+	bs, err := ioutil.ReadAll(f)
+	if err != nil {
+		fmt.Printf("reading input file: %v\n", err)
+		return -1, nil
+	}
+	typ := data.DetectFileType(bs)
+	if typ != data.FileXML {
+		return passThrough(bytes.NewReader(bs), infile, outfile, typ, &options), nil
 	}
 
-	if doc.Element == nil {
-		f.Seek(0, 0)
-		reader := bufio.NewReader(f)
-
-		// copy all data unmodified
-		outf, err := os.Create(outfile)
-		if err != nil {
-			fmt.Printf("%v\n", err)
-			return -1
-		}
-		defer outf.Close()
-
-		chunk := make([]byte, 16384)
-		for {
-			n, err := io.ReadFull(reader, chunk)
-			outf.Write(chunk[:n])
-			if err == io.EOF {
-				break
-			}
-		}
-		fmt.Println("OK (unchanged)")
-		return 1
+	name := infile
+	if infile == "-" {
+		name = ""
+	}
+	doc, err := data.ReadXMLNamed(bytes.NewReader(bs), name, &options)
+	if err == data.ErrPlainXML {
+		return passThrough(bytes.NewReader(bs), infile, outfile, data.FilePlainXML, &options), nil
+	} else if err == data.ErrFragment {
+		// only meant to be included by other files
+		fmt.Printf("skipped (%v)\n", data.FileFragment)
+		summary.AddSkipped(data.FileFragment, infile)
+		return 0, nil
+	} else if err != nil {
+		fmt.Printf("%v\n", err)
+		return -1, nil
 	}
 
 	if options.Debug {
@@ -220,18 +337,108 @@ func ProcessFile(infile string, outfile string, opts []string) int {
 		for i, v := range doc.TypedValues {
 			fmt.Printf("0x%x (%d): %v\n", i, i, v)
 		}
-		fmt.Printf("%v\n", doc)
+		fmt.Printf("%v\n", *doc)
 	}
 
-	f, err = os.Create(outfile)
+	bs, err = doc.Encode()
 	if err != nil {
-		fmt.Printf("opening output file: %v", err)
-		return -1
+		fmt.Printf("%v\n", err)
+		return -1, nil
 	}
-	defer f.Close()
 
-	result := doc.Encode()
-	f.Write(result)
+	outf, err := createOutput(outfile)
+	if err != nil {
+		fmt.Printf("opening output file: %v\n", err)
+		return -1, nil
+	}
+	defer outf.Close()
+
+	_, err = outf.Write(bs)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return -1, nil
+	}
 	fmt.Println("OK")
+	checkProfile(doc)
+	summary.Converted++
+	return 1, doc.Includes
+}
+
+// loadProfile loads the profile named by --profile, if any.
+func loadProfile(opts []string) (*data.Profile, error) {
+	for _, opt := range opts {
+		if strings.HasPrefix(opt, "--profile=") {
+			return data.LoadProfile(opt[len("--profile="):])
+		}
+	}
+	return nil, nil
+}
+
+// checkProfile prints what the profile doesn't know about doc.
+func checkProfile(doc *data.Document) {
+	if profile == nil {
+		return
+	}
+	for _, f := range profile.Check(doc) {
+		fmt.Printf("  %v\n", f)
+	}
+}
+
+// passThrough applies the pass-through policy to a file that is not in
+// the IBX1 XML dialect.
+func passThrough(reader io.Reader, infile string, outfile string, typ data.FileType, options *data.Options) int {
+	switch options.PassThrough {
+	case data.PassThroughSkip:
+		fmt.Printf("skipped (%v)\n", typ)
+		summary.AddSkipped(typ, infile)
+		return 0
+	case data.PassThroughError:
+		fmt.Printf("not in the IBX1 XML dialect (%v)\n", typ)
+		return -1
+	}
+
+	// copy all data unmodified
+	outf, err := createOutput(outfile)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return -1
+	}
+	defer outf.Close()
+
+	_, err = io.Copy(outf, reader)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return -1
+	}
+	fmt.Printf("OK (unchanged, %v)\n", typ)
+	summary.AddCopied(typ, infile)
 	return 1
 }
+
+// stdout is where "-" output goes. When writing to it, os.Stdout is
+// pointed at stderr so that progress messages don't mix with the data.
+var stdout io.Writer = os.Stdout
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// openInput opens the named file for reading, or stdin for "-".
+func openInput(name string) (io.ReadCloser, error) {
+	if name == "-" {
+		return ioutil.NopCloser(os.Stdin), nil
+	}
+	return os.Open(name)
+}
+
+// createOutput creates the named file, or returns stdout for "-".
+func createOutput(name string) (io.WriteCloser, error) {
+	if name == "-" {
+		return nopWriteCloser{stdout}, nil
+	}
+	return os.Create(name)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"juce/fifa-ibx1/data"
+	"os"
+	"strings"
+)
+
+// variant is one set of variable values to encode the input with. name is
+// empty unless the values come from a [section] of a variables file.
+type variant struct {
+	name string
+	opts []string
+}
+
+// splitDefinition splits "name=value".
+func splitDefinition(def string) (string, string) {
+	i := strings.Index(def, "=")
+	if i < 0 {
+		return def, ""
+	}
+	return def[:i], def[i+1:]
+}
+
+func checkDefinition(def string) error {
+	name, _ := splitDefinition(def)
+	if !strings.Contains(def, "=") || !data.IsVariableName(name) {
+		return fmt.Errorf("bad variable definition \"%s\": expected name=value", def)
+	}
+	return nil
+}
+
+// loadVariants turns the --vars=<file> options into --define options, one
+// set per [section] of the files. Definitions outside of sections apply
+// to every variant, and -D definitions override the files.
+func loadVariants(opts []string) ([]variant, error) {
+	var base, common, defines []string
+	var sections []string
+	sectionDefines := make(map[string][]string)
+	for _, opt := range opts {
+		switch {
+		case strings.HasPrefix(opt, "--vars="):
+			err := readVariables(opt[len("--vars="):], &common, &sections, sectionDefines)
+			if err != nil {
+				return nil, err
+			}
+		case strings.HasPrefix(opt, "--define="):
+			err := checkDefinition(opt[len("--define="):])
+			if err != nil {
+				return nil, err
+			}
+			defines = append(defines, opt)
+		default:
+			base = append(base, opt)
+		}
+	}
+	if len(sections) == 0 {
+		return []variant{{opts: concat(base, common, defines)}}, nil
+	}
+	var variants []variant
+	for _, name := range sections {
+		variants = append(variants, variant{name, concat(base, common, sectionDefines[name], defines)})
+	}
+	return variants, nil
+}
+
+func concat(lists ...[]string) []string {
+	var result []string
+	for _, list := range lists {
+		result = append(result, list...)
+	}
+	return result
+}
+
+// readVariables reads a variables file: name=value lines, optionally
+// grouped in [variant] sections. Empty lines and lines starting with #
+// are ignored.
+func readVariables(filename string, common *[]string, sections *[]string, sectionDefines map[string][]string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	section := ""
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		switch {
+		case text == "" || strings.HasPrefix(text, "#"):
+		case strings.HasPrefix(text, "[") && strings.HasSuffix(text, "]"):
+			section = strings.TrimSpace(text[1 : len(text)-1])
+			if !data.IsVariableName(section) || section == "." || section == ".." {
+				return fmt.Errorf("%s:%d: bad variant name \"%s\"", filename, line, section)
+			}
+			if _, ok := sectionDefines[section]; !ok {
+				*sections = append(*sections, section)
+				sectionDefines[section] = []string{}
+			}
+		default:
+			name, value := splitDefinition(text)
+			def := strings.TrimSpace(name) + "=" + strings.TrimSpace(value)
+			if err := checkDefinition(def); err != nil || !strings.Contains(text, "=") {
+				return fmt.Errorf("%s:%d: expected name=value or [variant]", filename, line)
+			}
+			if section == "" {
+				*common = append(*common, "--define="+def)
+			} else {
+				sectionDefines[section] = append(sectionDefines[section], "--define="+def)
+			}
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"juce/fifa-ibx1/data"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+const watchInterval = time.Second
+
+// Watch polls indir for new or modified XML files and re-encodes them
+// into outdir, mirroring the layout that ProcessDir produces. Errors are
+// reported as they happen and watching continues. It never returns.
+func Watch(indir string, outdir string, opts []string) {
+	var copyTo string
+	for _, opt := range opts {
+		if strings.HasPrefix(opt, "--copyto=") {
+			copyTo = opt[len("--copyto="):]
+		}
+	}
+
+	fmt.Printf("watching %s for changes (Ctrl-C to stop)\n", indir)
+	seen := make(map[string]time.Time)
+	includeTimes := make(map[string]map[string]time.Time)
+	initial := true
+	for {
+		ScanDir(indir, outdir, "", copyTo, opts, seen, includeTimes, initial)
+		initial = false
+		time.Sleep(watchInterval)
+	}
+}
+
+// ScanDir re-encodes every XML file under indir whose modification time
+// differs from the one recorded in seen, or one of whose included files
+// changed since it was recorded in includeTimes. On the initial scan, files
+// whose output is already up to date are only recorded.
+func ScanDir(indir string, outdir string, rel string, copyTo string, opts []string, seen map[string]time.Time, includeTimes map[string]map[string]time.Time, initial bool) {
+	entries, err := ioutil.ReadDir(indir)
+	if err != nil {
+		fmt.Printf("problem reading directory: %v\n", err)
+		return
+	}
+	for _, entry := range entries {
+		inItem := path.Join(indir, entry.Name())
+		outItem := path.Join(outdir, entry.Name())
+		relItem := path.Join(rel, entry.Name())
+		if entry.IsDir() {
+			ScanDir(inItem, outItem, relItem, copyTo, opts, seen, includeTimes, initial)
+			continue
+		}
+		if strings.ToLower(path.Ext(entry.Name())) != ".xml" {
+			continue
+		}
+		modTime, ok := seen[inItem]
+		if ok && modTime.Equal(entry.ModTime()) && !includesChanged(includeTimes[inItem]) {
+			continue
+		}
+		seen[inItem] = entry.ModTime()
+
+		ext := path.Ext(outItem)
+		outItem = fmt.Sprintf("%s%s", outItem[:len(outItem)-len(ext)], ".dat")
+		if initial {
+			fi, err := os.Stat(outItem)
+			if err == nil && !fi.ModTime().Before(entry.ModTime()) {
+				includeTimes[inItem] = modTimes(readIncludes(inItem))
+				continue
+			}
+		}
+
+		err = os.MkdirAll(outdir, 0775)
+		if err != nil {
+			fmt.Printf("problem creating output directory: %v\n", err)
+			continue
+		}
+		n, included := ProcessFile(inItem, outItem, opts)
+		includeTimes[inItem] = modTimes(included)
+		if n < 0 {
+			continue
+		}
+		if copyTo != "" {
+			ext = path.Ext(relItem)
+			target := path.Join(copyTo, fmt.Sprintf("%s%s", relItem[:len(relItem)-len(ext)], ".dat"))
+			err = CopyFile(outItem, target)
+			if err != nil {
+				fmt.Printf("copying %s --> %s: %v\n", outItem, target, err)
+				continue
+			}
+			fmt.Printf("copied %s --> %s\n", outItem, target)
+		}
+	}
+}
+
+// modTimes returns the modification times of the files included by a
+// converted file, so that editing a fragment re-encodes the files that
+// include it.
+func modTimes(included []string) map[string]time.Time {
+	times := make(map[string]time.Time)
+	for _, name := range included {
+		if fi, err := os.Stat(name); err == nil {
+			times[name] = fi.ModTime()
+		}
+	}
+	return times
+}
+
+// readIncludes returns the files an XML file includes, for files that are
+// up to date and so not converted by the initial scan.
+func readIncludes(name string) []string {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	doc, err := data.ReadXMLNamed(f, name, &data.Options{})
+	if err != nil {
+		return nil
+	}
+	return doc.Includes
+}
+
+// includesChanged reports whether any file recorded by modTimes changed.
+func includesChanged(times map[string]time.Time) bool {
+	for name, t := range times {
+		fi, err := os.Stat(name)
+		if err != nil || !fi.ModTime().Equal(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// CopyFile copies src to dst, creating the parent directories of dst.
+func CopyFile(src string, dst string) error {
+	err := os.MkdirAll(path.Dir(dst), 0775)
+	if err != nil {
+		return err
+	}
+	inf, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer inf.Close()
+	outf, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer outf.Close()
+	_, err = io.Copy(outf, inf)
+	return err
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"juce/fifa-ibx1/presentation"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+func init() {
+	commands = append(commands, &Command{
+		Name: "camera",
+		Args: "list [--all] <in-path>... | set [--owner=<name>] [--out=<out-path>] <in-path> <cameraName> <key>=<value>...",
+		Help: "tabulate the cameras of packages, or change camera parameters in place",
+		Run:  runCamera,
+	})
+}
+
+// cameraColumns are the parameters camera list shows by default.
+var cameraColumns = []string{"blendLength", "blendCurve", "transitionSpeed", "priority"}
+
+func cameraUsage() int {
+	fmt.Printf("Usage: %s camera list [--all] <in-path>...\n", os.Args[0])
+	fmt.Printf("       %s camera set [--owner=<name>] [--out=<out-path>] <in-path> <cameraName> <key>=<value>...\n", os.Args[0])
+	return 1
+}
+
+func runCamera(args []string) int {
+	if len(args) < 2 {
+		return cameraUsage()
+	}
+	opts := make(map[string]string)
+	var rest []string
+	for _, arg := range args[1:] {
+		if strings.HasPrefix(arg, "--") {
+			kv := strings.SplitN(arg[2:], "=", 2)
+			opts[kv[0]] = ""
+			if len(kv) == 2 {
+				opts[kv[0]] = kv[1]
+			}
+		} else {
+			rest = append(rest, arg)
+		}
+	}
+	switch args[0] {
+	case "list":
+		for opt := range opts {
+			if opt != "all" {
+				fmt.Printf("unknown option: --%s\n", opt)
+				return 1
+			}
+		}
+		_, all := opts["all"]
+		return listCameras(rest, all)
+	case "set":
+		for opt := range opts {
+			if opt != "owner" && opt != "out" {
+				fmt.Printf("unknown option: --%s\n", opt)
+				return 1
+			}
+		}
+		if len(rest) < 3 {
+			return cameraUsage()
+		}
+		out, ok := opts["out"]
+		if !ok {
+			out = rest[0]
+		}
+		return setCamera(rest[0], out, rest[1], opts["owner"], rest[2:])
+	}
+	return cameraUsage()
+}
+
+func listCameras(paths []string, all bool) int {
+	if len(paths) == 0 {
+		return cameraUsage()
+	}
+	var files []*docFile
+	for _, p := range paths {
+		fi, err := os.Stat(p)
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			return 1
+		}
+		if fi.IsDir() {
+			files, err = readDocumentDir(p, files)
+		} else {
+			files, err = readDocumentFile(p, files, true)
+		}
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			return 1
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	if !all {
+		fmt.Fprintf(w, "FILE\tOWNER\tCAMERA\t%s\tMODIFIERS\n", strings.Join(cameraColumns, "\t"))
+	}
+	status := 0
+	for _, f := range files {
+		if f.err != nil {
+			fmt.Printf("%s: %v\n", f.name, f.err)
+			status = 1
+			continue
+		}
+		for _, c := range presentation.Cameras(f.doc) {
+			if all {
+				fmt.Fprintf(w, "%s: %s / %s\n", f.name, c.Owner, c.Name)
+				for _, p := range c.Params() {
+					fmt.Fprintf(w, "  %s\t%s\t%s\n", p.Key, p.Type, p.Value)
+				}
+				continue
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s", f.name, dash(c.Owner), c.Name)
+			for _, key := range cameraColumns {
+				fmt.Fprintf(w, "\t%s", dash(c.Get(key)))
+			}
+			fmt.Fprintf(w, "\t%s\n", dash(c.Modifiers()))
+		}
+	}
+	w.Flush()
+	return status
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func setCamera(infile string, outfile string, name string, owner string, assignments []string) int {
+	doc, err := readDocument(infile)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return 1
+	}
+	var cameras []*presentation.Camera
+	for _, c := range presentation.Cameras(doc) {
+		if c.Name == name && (owner == "" || c.Owner == owner) {
+			cameras = append(cameras, c)
+		}
+	}
+	if len(cameras) == 0 {
+		fmt.Printf("%s: no camera named \"%s\"", infile, name)
+		if owner != "" {
+			fmt.Printf(" in \"%s\"", owner)
+		}
+		fmt.Println()
+		return 1
+	}
+	for _, c := range cameras {
+		for _, a := range assignments {
+			i := strings.Index(a, "=")
+			if i <= 0 {
+				fmt.Printf("expected <key>=<value>: %s\n", a)
+				return 1
+			}
+			key, value := a[:i], a[i+1:]
+			old := c.Get(key)
+			err = c.Set(key, value)
+			if err != nil {
+				fmt.Printf("%s / %s: %v\n", c.Owner, c.Name, err)
+				return 1
+			}
+			fmt.Printf("%s / %s: %s %s -> %s\n", c.Owner, c.Name, key, dash(old), c.Get(key))
+		}
+	}
+	doc.Compact()
+	err = writeDocument(doc, outfile)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"juce/fifa-ibx1/presentation"
+	"os"
+)
+
+func init() {
+	commands = append(commands, &Command{
+		Name: "dot",
+		Args: "<in-path> [out-path]",
+		Help: "export the ProcessGraphs of a DAT or XML file as a Graphviz digraph",
+		Run:  runDot,
+	})
+}
+
+func runDot(args []string) int {
+	if len(args) < 1 {
+		fmt.Printf("Usage: %s dot <in-path> [out-path]\n", os.Args[0])
+		return 1
+	}
+	doc, err := readDocument(args[0])
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return 1
+	}
+	out := os.Stdout
+	if len(args) > 1 && args[1] != "-" {
+		out, err = os.Create(args[1])
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			return 1
+		}
+		defer out.Close()
+	}
+	writer := bufio.NewWriter(out)
+	err = presentation.WriteDot(writer, doc)
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"juce/fifa-ibx1/data"
+	"os"
+)
+
+func init() {
+	commands = append(commands, &Command{
+		Name: "dump",
+		Args: "<in-path>",
+		Help: "print every byte range of an IBX1 file with its meaning",
+		Run:  runDump,
+	})
+}
+
+func runDump(args []string) int {
+	if len(args) < 1 {
+		fmt.Printf("Usage: %s dump <in-path>\n", os.Args[0])
+		return 1
+	}
+	f, err := openInput(args[0])
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return 1
+	}
+	defer f.Close()
+
+	writer := bufio.NewWriter(os.Stdout)
+	defer writer.Flush()
+	err = data.Dump(bufio.NewReader(f), writer)
+	if err != nil {
+		writer.Flush()
+		fmt.Printf("%v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"juce/fifa-ibx1/data"
+	"juce/fifa-ibx1/presentation"
+	"os"
+	"strconv"
+)
+
+func init() {
+	commands = append(commands, &Command{
+		Name: "expr",
+		Args: "list <in-path> | parse <text> | set <in-path> <out-path> <n> <text>",
+		Help: "show expressions as infix text, turn text into XML, or replace expression #n",
+		Run:  runExpr,
+	})
+}
+
+func exprUsage() int {
+	fmt.Printf("Usage: %s expr list <in-path>\n", os.Args[0])
+	fmt.Printf("       %s expr parse <text>\n", os.Args[0])
+	fmt.Printf("       %s expr set <in-path> <out-path> <n> <text>\n", os.Args[0])
+	return 1
+}
+
+func runExpr(args []string) int {
+	if len(args) < 2 {
+		return exprUsage()
+	}
+	switch args[0] {
+	case "list":
+		doc, err := readDocument(args[1])
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			return 1
+		}
+		for i, ref := range presentation.Expressions(doc) {
+			s, err := presentation.FormatExpression(ref.Node)
+			if err != nil {
+				s = fmt.Sprintf("(%v)", err)
+			}
+			fmt.Printf("#%d %s: %s\n", i, ref.Path, s)
+		}
+		return 0
+	case "parse":
+		doc := data.NewDocument()
+		n, err := presentation.ParseExpression(doc, args[1])
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			return 1
+		}
+		doc.Element = n
+		err = doc.WriteXML(os.Stdout, &data.Options{})
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			return 1
+		}
+		fmt.Println()
+		return 0
+	case "set":
+		if len(args) < 5 {
+			return exprUsage()
+		}
+		return setExpr(args[1], args[2], args[3], args[4])
+	}
+	return exprUsage()
+}
+
+func setExpr(infile string, outfile string, index string, text string) int {
+	doc, err := readDocument(infile)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return 1
+	}
+	refs := presentation.Expressions(doc)
+	i, err := strconv.Atoi(index)
+	if err != nil || i < 0 || i >= len(refs) {
+		fmt.Printf("no expression #%s (the file has %d)\n", index, len(refs))
+		return 1
+	}
+	n, err := presentation.ParseExpression(doc, text)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return 1
+	}
+	parent := doc.Parent(refs[i].Node)
+	if parent == nil {
+		// the expression is the root element
+		doc.Element = n
+	} else {
+		for j, c := range parent.Children {
+			if c == refs[i].Node {
+				parent.Children[j] = n
+			}
+		}
+	}
+	doc.Compact()
+	err = writeDocument(doc, outfile)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"juce/fifa-ibx1/data"
+	"os"
+	"strings"
+)
+
+func init() {
+	commands = append(commands, &Command{
+		Name: "lint",
+		Args: "[--level=info|warning|error] [--profile=<name>] <in-path>...",
+		Help: "report structural problems in IBX1 files or directories of them",
+		Run:  runLint,
+	})
+}
+
+func runLint(args []string) int {
+	level := data.SeverityWarning
+	var profile *data.Profile
+	var paths []string
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "--level=") {
+			var ok bool
+			level, ok = data.ParseSeverity(arg[len("--level="):])
+			if !ok {
+				fmt.Printf("unknown level: %s\n", arg[len("--level="):])
+				return 1
+			}
+		} else if strings.HasPrefix(arg, "--profile=") {
+			var err error
+			profile, err = data.LoadProfile(arg[len("--profile="):])
+			if err != nil {
+				fmt.Printf("%v\n", err)
+				return 1
+			}
+		} else if strings.HasPrefix(arg, "--") {
+			fmt.Printf("unknown option: %s\n", arg)
+			return 1
+		} else {
+			paths = append(paths, arg)
+		}
+	}
+	if len(paths) == 0 {
+		fmt.Printf("Usage: %s lint [--level=info|warning|error] [--profile=<name>] <in-path>...\n", os.Args[0])
+		return 1
+	}
+
+	// read everything first: property types are compared across all files
+	var files []*docFile
+	for _, p := range paths {
+		fi, err := os.Stat(p)
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			return 1
+		}
+		if fi.IsDir() {
+			files, err = readDocumentDir(p, files)
+		} else {
+			files, err = readDocumentFile(p, files, true)
+		}
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			return 1
+		}
+	}
+	stats := data.NewTypeStats()
+	for _, f := range files {
+		if f.doc != nil {
+			stats.Add(f.doc)
+		}
+	}
+
+	counts := make(map[data.Severity]int)
+	for _, f := range files {
+		if f.err != nil {
+			counts[data.SeverityError]++
+			fmt.Printf("%s: error: %v\n", f.name, f.err)
+			continue
+		}
+		findings := append(f.doc.Lint(), stats.Lint(f.doc)...)
+		if profile != nil {
+			findings = append(findings, profile.Check(f.doc)...)
+		}
+		for _, finding := range findings {
+			counts[finding.Severity]++
+			if finding.Severity >= level {
+				fmt.Printf("%s: %v\n", f.name, finding)
+			}
+		}
+	}
+	fmt.Printf("%d files: %d errors, %d warnings, %d infos\n", len(files),
+		counts[data.SeverityError], counts[data.SeverityWarning], counts[data.SeverityInfo])
+	if counts[data.SeverityError] > 0 {
+		return 1
+	}
+	return 0
+}
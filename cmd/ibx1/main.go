@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"juce/fifa-ibx1/data"
+	"os"
+	"path"
+	"strings"
+)
+
+var Version = "unknown"
+
+type Command struct {
+	Name string
+	Args string
+	Help string
+	Run  func(args []string) int
+}
+
+var commands []*Command
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(0)
+	}
+	name := os.Args[1]
+	for _, cmd := range commands {
+		if cmd.Name == name {
+			os.Exit(cmd.Run(os.Args[2:]))
+		}
+	}
+	fmt.Printf("unknown command: %s\n", name)
+	usage()
+	os.Exit(1)
+}
+
+func usage() {
+	fmt.Printf("FIFA IBX1 Tools by juce. Version: %s\n", Version)
+	fmt.Printf("Usage: %s <command> [arguments]\n", os.Args[0])
+	fmt.Printf("Commands:\n")
+	for _, cmd := range commands {
+		fmt.Printf("\t%s %s\n\t\t%s\n", cmd.Name, cmd.Args, cmd.Help)
+	}
+}
+
+// openInput opens the named file for reading, or stdin for "-".
+func openInput(name string) (io.ReadCloser, error) {
+	if name == "-" {
+		return ioutil.NopCloser(os.Stdin), nil
+	}
+	return os.Open(name)
+}
+
+// readDocument reads an IBX1 file or its XML form, or stdin for "-".
+func readDocument(name string) (*data.Document, error) {
+	f, err := openInput(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	reader := bufio.NewReader(f)
+	head, _ := reader.Peek(512)
+	switch typ := data.DetectFileType(head); typ {
+	case data.FileIBX1:
+		return data.ReadDocument(reader, &data.Options{})
+	case data.FileXML:
+		xmlName := name
+		if name == "-" {
+			xmlName = ""
+		}
+		doc, err := data.ReadXMLNamed(reader, xmlName, &data.Options{})
+		if err == data.ErrPlainXML || err == data.ErrFragment {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		return doc, err
+	default:
+		return nil, fmt.Errorf("%s: %s file is neither IBX1 nor XML", name, typ)
+	}
+}
+
+// writeDocument writes doc as XML if name ends in .xml, and as an IBX1
+// file otherwise. "-" writes XML to stdout.
+func writeDocument(doc *data.Document, name string) error {
+	if name == "-" {
+		return doc.WriteXML(os.Stdout, &data.Options{})
+	}
+	var bs []byte
+	if strings.ToLower(path.Ext(name)) != ".xml" {
+		// encode first, so that a failure leaves the file alone
+		var err error
+		bs, err = doc.Encode()
+		if err != nil {
+			return err
+		}
+	}
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(f)
+	if bs == nil {
+		err = doc.WriteXML(writer, &data.Options{})
+	} else {
+		_, err = writer.Write(bs)
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// docFile is a document read by readDocumentDir or readDocumentFile, or
+// the error that prevented reading it.
+type docFile struct {
+	name string
+	doc  *data.Document
+	err  error
+}
+
+// readDocumentDir reads the IBX1 and IBX1 XML files of a directory tree.
+func readDocumentDir(dir string, files []*docFile) ([]*docFile, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return files, err
+	}
+	for _, entry := range entries {
+		p := path.Join(dir, entry.Name())
+		if entry.IsDir() {
+			files, err = readDocumentDir(p, files)
+		} else {
+			files, err = readDocumentFile(p, files, false)
+		}
+		if err != nil {
+			return files, err
+		}
+	}
+	return files, nil
+}
+
+// readDocumentFile reads an IBX1 file or its XML form. Other files found
+// in directories are skipped, but naming one explicitly is an error.
+func readDocumentFile(name string, files []*docFile, explicit bool) ([]*docFile, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return files, err
+	}
+	defer f.Close()
+	reader := bufio.NewReader(f)
+	head, _ := reader.Peek(512)
+	var doc *data.Document
+	switch data.DetectFileType(head) {
+	case data.FileIBX1:
+		doc, err = data.ReadDocument(reader, &data.Options{})
+	case data.FileXML:
+		doc, err = data.ReadXMLNamed(reader, name, &data.Options{})
+		if (err == data.ErrPlainXML || err == data.ErrFragment) && !explicit {
+			return files, nil
+		}
+	default:
+		if explicit {
+			return files, fmt.Errorf("%s: not an IBX1 file", name)
+		}
+		return files, nil
+	}
+	if err != nil {
+		return append(files, &docFile{name: name, err: err}), nil
+	}
+	return append(files, &docFile{name: name, doc: doc}), nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"juce/fifa-ibx1/data"
+	"os"
+	"strings"
+)
+
+func init() {
+	commands = append(commands, &Command{
+		Name: "profile",
+		Args: "list | make [--name=<name>] [--description=<text>] <in-path>...",
+		Help: "list the game profiles found, or build one from known-good files",
+		Run:  runProfile,
+	})
+}
+
+func profileUsage() int {
+	fmt.Printf("Usage: %s profile list\n", os.Args[0])
+	fmt.Printf("       %s profile make [--name=<name>] [--description=<text>] <in-path>... > <name>.json\n", os.Args[0])
+	return 1
+}
+
+func runProfile(args []string) int {
+	if len(args) == 0 {
+		return profileUsage()
+	}
+	switch args[0] {
+	case "list":
+		fmt.Printf("searched: %s\n", strings.Join(data.ProfileDirs(), ", "))
+		for _, name := range data.ListProfiles() {
+			p, err := data.LoadProfile(name)
+			if err != nil {
+				fmt.Printf("%s: %v\n", name, err)
+				continue
+			}
+			fmt.Printf("%s\t%s\n", name, p.Description)
+		}
+		return 0
+	case "make":
+		return makeProfile(args[1:])
+	}
+	return profileUsage()
+}
+
+func makeProfile(args []string) int {
+	var name, description string
+	var files []*docFile
+	for _, arg := range args {
+		switch {
+		case strings.HasPrefix(arg, "--name="):
+			name = arg[len("--name="):]
+			continue
+		case strings.HasPrefix(arg, "--description="):
+			description = arg[len("--description="):]
+			continue
+		case strings.HasPrefix(arg, "--"):
+			fmt.Printf("unknown option: %s\n", arg)
+			return 1
+		}
+		fi, err := os.Stat(arg)
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			return 1
+		}
+		if fi.IsDir() {
+			files, err = readDocumentDir(arg, files)
+		} else {
+			files, err = readDocumentFile(arg, files, true)
+		}
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			return 1
+		}
+	}
+	if len(files) == 0 {
+		return profileUsage()
+	}
+
+	var docs []*data.Document
+	for _, f := range files {
+		if f.err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", f.name, f.err)
+			return 1
+		}
+		docs = append(docs, f.doc)
+	}
+	p := data.NewProfile(name, docs)
+	p.Description = description
+	err := p.WriteJSON(os.Stdout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"juce/fifa-ibx1/presentation"
+	"os"
+	"strings"
+)
+
+func init() {
+	commands = append(commands, &Command{
+		Name: "refs",
+		Args: "[--format=text|dot|json] <in-dir>",
+		Help: "check the references between the files of a folder, or export them as a graph",
+		Run:  runRefs,
+	})
+}
+
+func runRefs(args []string) int {
+	format := "text"
+	var dirs []string
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "--format=") {
+			format = arg[len("--format="):]
+		} else if strings.HasPrefix(arg, "--") {
+			fmt.Printf("unknown option: %s\n", arg)
+			return 1
+		} else {
+			dirs = append(dirs, arg)
+		}
+	}
+	if len(dirs) != 1 {
+		fmt.Printf("Usage: %s refs [--format=text|dot|json] <in-dir>\n", os.Args[0])
+		return 1
+	}
+	g, err := presentation.ReadRefGraph(dirs[0])
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return 1
+	}
+
+	writer := bufio.NewWriter(os.Stdout)
+	defer writer.Flush()
+	switch format {
+	case "dot":
+		err = g.WriteDot(writer)
+	case "json":
+		err = g.WriteJSON(writer)
+	case "text":
+		for _, e := range g.Errors {
+			fmt.Fprintf(writer, "error: %s\n", e)
+		}
+		for _, r := range g.Dangling() {
+			fmt.Fprintf(writer, "%s: dangling %s reference %s \"%s\"\n", r.From, r.Kind, r.Source, r.Value)
+		}
+		for _, f := range g.Orphans {
+			fmt.Fprintf(writer, "%s: not referenced by any file\n", f)
+		}
+		fmt.Fprintf(writer, "%d files, %d references: %d dangling, %d orphaned files\n",
+			len(g.Files), len(g.Refs), len(g.Dangling()), len(g.Orphans))
+	default:
+		fmt.Printf("unknown format: %s\n", format)
+		return 1
+	}
+	if err != nil {
+		writer.Flush()
+		fmt.Printf("%v\n", err)
+		return 1
+	}
+	if len(g.Dangling()) > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"juce/fifa-ibx1/presentation"
+	"os"
+	"strings"
+)
+
+func init() {
+	commands = append(commands, &Command{
+		Name: "simulate",
+		Args: "[--graph=<name>] [<variable>=<value>]... <in-path>...",
+		Help: "show which nodes and cameras of a ProcessGraph run for the given variable values",
+		Run:  runSimulate,
+	})
+}
+
+func runSimulate(args []string) int {
+	sim := presentation.NewSimulator()
+	graphName := ""
+	var paths []string
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "--graph=") {
+			graphName = arg[len("--graph="):]
+		} else if strings.HasPrefix(arg, "--") {
+			fmt.Printf("unknown option: %s\n", arg)
+			return 1
+		} else if i := strings.Index(arg, "="); i > 0 {
+			sim.Variables[arg[:i]] = presentation.ParseValue(arg[i+1:])
+		} else {
+			paths = append(paths, arg)
+		}
+	}
+	if len(paths) == 0 {
+		fmt.Printf("Usage: %s simulate [--graph=<name>] [<variable>=<value>]... <in-path>...\n", os.Args[0])
+		return 1
+	}
+
+	var files []*docFile
+	for _, p := range paths {
+		fi, err := os.Stat(p)
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			return 1
+		}
+		if fi.IsDir() {
+			files, err = readDocumentDir(p, files)
+		} else {
+			files, err = readDocumentFile(p, files, true)
+		}
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			return 1
+		}
+	}
+	for _, f := range files {
+		if f.err != nil {
+			fmt.Printf("%s: %v\n", f.name, f.err)
+			return 1
+		}
+		sim.AddDocument(f.doc)
+		if graphName == "" && f.doc.Element != nil {
+			// default: the first graph of the first file
+			if graphs := f.doc.Element.Find(presentation.ProcessGraph); len(graphs) > 0 {
+				graphName = presentation.StringProperty(graphs[0], "name")
+			}
+		}
+	}
+	graph := sim.Graph(graphName)
+	if graph == nil {
+		fmt.Printf("no ProcessGraph named \"%s\"\n", graphName)
+		return 1
+	}
+
+	sim.Run(graph)
+	for _, step := range sim.Trace {
+		marker := "  "
+		if step.Camera {
+			marker = "* "
+		}
+		fmt.Printf("%s%s%s\n", marker, strings.Repeat("  ", step.Depth), step.Text)
+	}
+	if len(sim.Unset) > 0 {
+		fmt.Printf("unset variables (taken as 0): %s\n", strings.Join(sim.Unset, ", "))
+	}
+	return 0
+}
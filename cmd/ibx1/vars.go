@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"juce/fifa-ibx1/data"
+	"juce/fifa-ibx1/presentation"
+	"os"
+	"sort"
+	"strings"
+)
+
+func init() {
+	commands = append(commands, &Command{
+		Name: "vars",
+		Args: "[--level=info|warning|error] list|check <in-path>...",
+		Help: "list the variables of a folder, or check their uses",
+		Run:  runVars,
+	})
+}
+
+func varsUsage() int {
+	fmt.Printf("Usage: %s vars list <in-path>...\n", os.Args[0])
+	fmt.Printf("       %s vars [--level=info|warning|error] check <in-path>...\n", os.Args[0])
+	return 1
+}
+
+func runVars(args []string) int {
+	level := data.SeverityWarning
+	var rest []string
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "--level=") {
+			var ok bool
+			level, ok = data.ParseSeverity(arg[len("--level="):])
+			if !ok {
+				fmt.Printf("unknown level: %s\n", arg[len("--level="):])
+				return 1
+			}
+		} else if strings.HasPrefix(arg, "--") {
+			fmt.Printf("unknown option: %s\n", arg)
+			return 1
+		} else {
+			rest = append(rest, arg)
+		}
+	}
+	if len(rest) < 2 || (rest[0] != "list" && rest[0] != "check") {
+		return varsUsage()
+	}
+
+	var files []*docFile
+	for _, p := range rest[1:] {
+		fi, err := os.Stat(p)
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			return 1
+		}
+		if fi.IsDir() {
+			files, err = readDocumentDir(p, files)
+		} else {
+			files, err = readDocumentFile(p, files, true)
+		}
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			return 1
+		}
+	}
+	cat := presentation.NewCatalogue()
+	for _, f := range files {
+		if f.doc != nil {
+			cat.AddDocument(f.name, f.doc)
+		}
+	}
+
+	counts := make(map[data.Severity]int)
+	report := func(name string, finding data.Finding) {
+		counts[finding.Severity]++
+		if finding.Severity < level || rest[0] != "check" {
+			return
+		}
+		fmt.Printf("%s: %v\n", name, finding)
+	}
+	for _, f := range files {
+		if f.err != nil {
+			report(f.name, data.Finding{Severity: data.SeverityError, Offset: -1, Message: f.err.Error()})
+			continue
+		}
+		for _, finding := range cat.Check(f.doc) {
+			report(f.name, finding)
+		}
+	}
+	for _, def := range cat.Unused() {
+		report(def.File, data.Finding{Severity: data.SeverityWarning, Offset: -1, Message: fmt.Sprintf("action variable %s is never used", def.Name)})
+	}
+	for _, finding := range cat.Conflicts() {
+		report("*", finding)
+	}
+
+	if rest[0] == "list" {
+		for _, def := range cat.Sorted() {
+			fmt.Printf("%s\t%s\t%s\t%d uses\t%s\n", def.Name, def.Type, def.Set, def.Uses, def.File)
+		}
+		for _, u := range cat.SortedEvents() {
+			var types []string
+			for t := range u.Types {
+				types = append(types, t)
+			}
+			sort.Strings(types)
+			fmt.Printf("%s\t%s\t\t%d uses\n", u.Name, strings.Join(types, ","), u.Uses)
+		}
+		return 0
+	}
+	fmt.Printf("%d files: %d errors, %d warnings\n", len(files),
+		counts[data.SeverityError], counts[data.SeverityWarning])
+	if counts[data.SeverityError] > 0 {
+		return 1
+	}
+	return 0
+}
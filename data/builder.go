@@ -0,0 +1,114 @@
+package data
+
+// NewDocument returns an empty document that shares equal typed values.
+// Build the tree with NewNode and assign the root to Element:
+//
+//	doc := data.NewDocument()
+//	cam := doc.NewNode("CameraInstance").
+//		SetString("cameraName", "UserGameplayCamera").
+//		SetFloat("blendLength", 0.5)
+//	doc.Element = doc.NewNode("import").AddChild(cam)
+func NewDocument() *Document {
+	return &Document{ShareTypedValues: true, EncodingFlag: DefaultEncodingFlag}
+}
+
+// NewNode returns a new node of this document with the given name. It
+// is not part of the tree until added with AddChild or assigned to
+// Element.
+func (d *Document) NewNode(name string) *Node {
+	return &Node{
+		Name:       d.GetString(name),
+		Properties: []*Property{},
+		Children:   []*Node{},
+		Offset:     -1,
+		doc:        d,
+	}
+}
+
+// attach makes n and its subtree belong to d, so that the Set methods
+// can be used on them.
+func (d *Document) attach(n *Node) {
+	n.doc = d
+	for _, c := range n.Children {
+		d.attach(c)
+	}
+}
+
+// Document returns the document n belongs to, or nil.
+func (n *Node) Document() *Document {
+	return n.doc
+}
+
+func (n *Node) document() *Document {
+	if n.doc == nil {
+		panic("data: node does not belong to a document")
+	}
+	return n.doc
+}
+
+// AddChild appends c to the children of n and returns n.
+func (n *Node) AddChild(c *Node) *Node {
+	n.Children = append(n.Children, c)
+	return n
+}
+
+// Property returns the first property of n with the given name, or nil.
+func (n *Node) Property(name string) *Property {
+	d := n.document()
+	for _, p := range n.Properties {
+		if d.Strings[p.Name] == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// Set sets the property name to the typed value tv and returns n. An
+// existing property with that name keeps its position; otherwise the
+// property is appended.
+func (n *Node) Set(name string, tv TypedValue) *Node {
+	d := n.document()
+	value := d.AddTypedValue(tv)
+	if p := n.Property(name); p != nil {
+		p.Value = value
+		return n
+	}
+	n.Properties = append(n.Properties, &Property{Name: d.GetString(name), Value: value, Offset: -1})
+	return n
+}
+
+func (n *Node) SetInt8(name string, v int8) *Node {
+	return n.Set(name, Int8{v})
+}
+
+func (n *Node) SetUInt8(name string, v uint8) *Node {
+	return n.Set(name, UInt8{v})
+}
+
+func (n *Node) SetInt16(name string, v int16) *Node {
+	return n.Set(name, Int16{v})
+}
+
+func (n *Node) SetUInt16(name string, v uint16) *Node {
+	return n.Set(name, UInt16{v})
+}
+
+func (n *Node) SetInt32(name string, v int32) *Node {
+	return n.Set(name, Int32{v})
+}
+
+func (n *Node) SetUInt32(name string, v uint32) *Node {
+	return n.Set(name, UInt32{v})
+}
+
+func (n *Node) SetFloat(name string, v float32) *Node {
+	return n.Set(name, Float{v})
+}
+
+func (n *Node) SetBool(name string, v bool) *Node {
+	return n.Set(name, Bool{v})
+}
+
+func (n *Node) SetString(name string, v string) *Node {
+	return n.Set(name, String{n.document().GetString(v)})
+}
@@ -0,0 +1,258 @@
+package data
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// dumpReader records the bytes consumed through it, so that each item
+// read from the file can be printed together with its raw bytes.
+type dumpReader struct {
+	r      *bufio.Reader
+	offset int
+	bytes  []byte
+}
+
+func (r *dumpReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.offset += n
+	r.bytes = append(r.bytes, p[:n]...)
+	return n, err
+}
+
+func (r *dumpReader) ReadByte() (byte, error) {
+	b, err := r.r.ReadByte()
+	if err == nil {
+		r.offset++
+		r.bytes = append(r.bytes, b)
+	}
+	return b, err
+}
+
+// mark starts recording a new item and returns its offset.
+func (r *dumpReader) mark() int {
+	r.bytes = nil
+	return r.offset
+}
+
+const dumpBytesPerLine = 8
+
+type dumper struct {
+	r       *dumpReader
+	w       io.Writer
+	strings []string
+	values  []string
+}
+
+// line prints the bytes of one item, starting at offset, with its
+// description indented by depth. Long byte ranges continue on the
+// following lines.
+func (d *dumper) line(offset int, depth int, format string, args ...interface{}) {
+	bs := d.r.bytes
+	text := strings.Repeat("  ", depth) + fmt.Sprintf(format, args...)
+	for {
+		n := len(bs)
+		if n > dumpBytesPerLine {
+			n = dumpBytesPerLine
+		}
+		parts := make([]string, n)
+		for i, b := range bs[:n] {
+			parts[i] = fmt.Sprintf("%02x", b)
+		}
+		line := fmt.Sprintf("0x%06x  %-*s  %s", offset, dumpBytesPerLine*3-1, strings.Join(parts, " "), text)
+		fmt.Fprintln(d.w, strings.TrimRight(line, " "))
+		bs = bs[n:]
+		offset += n
+		text = ""
+		if len(bs) == 0 {
+			return
+		}
+	}
+}
+
+// numberForm describes the width marker of an encoded Number.
+func numberForm(bs []byte) string {
+	if len(bs) == 0 {
+		return ""
+	}
+	switch {
+	case bs[0] < 0x40:
+		return "1-byte"
+	case bs[0] == 0x40:
+		return "0x40+u8"
+	case bs[0] == 0x80:
+		return "0x80+u16"
+	}
+	return fmt.Sprintf("0x%02x", bs[0])
+}
+
+// propertyForm describes how the name index of a property is encoded.
+func propertyForm(b byte) string {
+	switch {
+	case b < 0xa0:
+		return "0x80+n"
+	case b == 0xa0:
+		return "0xa0+u8"
+	case b == 0xc0:
+		return "0xc0+u16"
+	}
+	return fmt.Sprintf("0x%02x", b)
+}
+
+func (d *dumper) str(index int) string {
+	if index >= 0 && index < len(d.strings) {
+		return fmt.Sprintf("%q", d.strings[index])
+	}
+	return "(out of range)"
+}
+
+func (d *dumper) number(depth int, label string) (int, error) {
+	offset := d.r.mark()
+	n, err := ReadNumber(d.r)
+	if err != nil {
+		return 0, fmt.Errorf("0x%x: reading %s: %v", offset, label, err)
+	}
+	d.line(offset, depth, "%s: %d (%s)", label, n.Value, numberForm(d.r.bytes))
+	return n.Value, nil
+}
+
+// Dump walks an IBX1 file and writes every byte range with its meaning:
+// signature, string and typed-value tables, encoding flag and the node
+// structure, indented by tree depth.
+func Dump(reader *bufio.Reader, w io.Writer) error {
+	d := &dumper{r: &dumpReader{r: reader}, w: w}
+
+	offset := d.r.mark()
+	sig := make([]byte, 4)
+	_, err := io.ReadFull(d.r, sig)
+	if err != nil {
+		return fmt.Errorf("reading signature: %v", err)
+	}
+	if string(sig) != "IBX1" {
+		return fmt.Errorf("not an IBX1 file")
+	}
+	d.line(offset, 0, "signature %q", string(sig))
+
+	// strings
+	numStrings, err := d.number(0, "number of strings")
+	if err != nil {
+		return err
+	}
+	for i := 0; i < numStrings; i++ {
+		n, err := d.number(1, fmt.Sprintf("string #%d length", i))
+		if err != nil {
+			return err
+		}
+		offset = d.r.mark()
+		bs := make([]byte, n+1)
+		_, err = io.ReadFull(d.r, bs)
+		if err != nil {
+			return fmt.Errorf("0x%x: reading string #%d: %v", offset, i, err)
+		}
+		s := string(bs[:n])
+		if bs[n] == 0 {
+			d.line(offset, 1, "string #%d: %q + NUL", i, s)
+		} else {
+			d.line(offset, 1, "string #%d: %q + terminator 0x%02x (expected NUL)", i, s, bs[n])
+		}
+		d.strings = append(d.strings, s)
+	}
+
+	// typed values
+	numValues, err := d.number(0, "number of typed values")
+	if err != nil {
+		return err
+	}
+	for i := 0; i < numValues; i++ {
+		offset = d.r.mark()
+		tv, err := ReadTypedValue(d.r)
+		if err != nil {
+			d.line(offset, 1, "value #%d: %v", i, err)
+			return fmt.Errorf("0x%x: reading typed value #%d: %v", offset, i, err)
+		}
+		var desc string
+		if v, ok := tv.(String); ok {
+			desc = fmt.Sprintf("string #%d %s", v.Value, d.str(v.Value))
+		} else {
+			doc := &Document{}
+			typ, val := doc.GetTypeAndValue(tv, &Options{})
+			desc = fmt.Sprintf("%s %s", typ, val)
+		}
+		d.line(offset, 1, "value #%d: type 0x%02x %s", i, d.r.bytes[0], desc)
+		d.values = append(d.values, desc)
+	}
+
+	// encoding flag
+	offset = d.r.mark()
+	flag, err := d.r.ReadByte()
+	if err != nil {
+		return fmt.Errorf("0x%x: reading encoding flag: %v", offset, err)
+	}
+	if flag == DefaultEncodingFlag {
+		d.line(offset, 0, "encoding flag 0x%02x", flag)
+	} else {
+		d.line(offset, 0, "encoding flag 0x%02x (unknown; nodes read with the 0x%02x layout)", flag, DefaultEncodingFlag)
+	}
+
+	// node structure
+	err = d.node(0)
+	if err != nil {
+		return err
+	}
+
+	offset = d.r.mark()
+	rest, _ := io.Copy(ioutil.Discard, d.r)
+	if rest > 0 {
+		fmt.Fprintf(d.w, "0x%06x  %d trailing bytes\n", offset, rest)
+	}
+	return nil
+}
+
+func (d *dumper) node(depth int) error {
+	offset := d.r.mark()
+	b, err := d.r.ReadByte()
+	if err != nil {
+		return fmt.Errorf("0x%x: reading node: %v", offset, err)
+	}
+	if b != 0 {
+		return fmt.Errorf("0x%x: element must start with 0-byte", offset)
+	}
+	name, err := ReadNumber(d.r)
+	if err != nil {
+		return fmt.Errorf("0x%x: reading node name: %v", offset, err)
+	}
+	numProps, err := ReadNumber(d.r)
+	if err != nil {
+		return fmt.Errorf("0x%x: reading number of properties: %v", offset, err)
+	}
+	numChildren, err := ReadNumber(d.r)
+	if err != nil {
+		return fmt.Errorf("0x%x: reading number of children: %v", offset, err)
+	}
+	d.line(offset, depth, "node %s (name #%d, %d properties, %d children)",
+		d.str(name.Value), name.Value, numProps.Value, numChildren.Value)
+
+	for i := 0; i < numProps.Value; i++ {
+		offset = d.r.mark()
+		p, err := ReadProperty(d.r)
+		if err != nil {
+			return fmt.Errorf("0x%x: reading property: %v", offset, err)
+		}
+		desc := "(out of range)"
+		if p.Value >= 0 && p.Value < len(d.values) {
+			desc = d.values[p.Value]
+		}
+		d.line(offset, depth+1, "property %s (%s name #%d) = value #%d: %s",
+			d.str(p.Name), propertyForm(d.r.bytes[0]), p.Name, p.Value, desc)
+	}
+	for i := 0; i < numChildren.Value; i++ {
+		err = d.node(depth + 1)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -5,52 +5,92 @@ import (
 	"bytes"
 	"encoding/binary"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
 	"strings"
 )
 
 type Options struct {
-	Hex8    bool
-	Hex16   bool
-	Hex32   bool
-	Debug   bool
-	NoShare bool
+	Hex8        bool
+	Hex16       bool
+	Hex32       bool
+	Debug       bool
+	NoShare     bool
+	Annotate    bool
+	Compact     bool
+	PassThrough string
+	Variables   map[string]string // values for ${name} in XML property values
+	// read documents with an unknown encoding flag as if it were 0x01
+	AnyEncodingFlag bool
+
+	valueRefs []int // references to each typed value, set by WriteXML to annotate
+}
+
+// DefaultEncodingFlag is the encoding flag of all known IBX1 files, the
+// byte between the typed values and the node structure. The node layout
+// is only known for this value.
+const DefaultEncodingFlag = 0x01
+
+// checkEncodingFlag returns an error for flags whose node layout is
+// unknown, unless options allow them.
+func checkEncodingFlag(flag byte, options *Options) error {
+	if flag == DefaultEncodingFlag || options.AnyEncodingFlag {
+		return nil
+	}
+	return fmt.Errorf("unsupported encoding flag 0x%02x: only 0x%02x is known", flag, DefaultEncodingFlag)
+}
+
+// encodingFlag returns the flag to write. Documents built in code leave
+// EncodingFlag zero, which stands for DefaultEncodingFlag.
+func (d *Document) encodingFlag() byte {
+	if d.EncodingFlag == 0 {
+		return DefaultEncodingFlag
+	}
+	return d.EncodingFlag
+}
+
+// MaxIndex is the largest string index, typed-value index, count or
+// string length the known IBX1 encodings can hold. Larger forms may exist,
+// but no known file uses them, so the encoders refuse such values rather
+// than guess.
+const MaxIndex = 0xffff
+
+// indexError is the error of the Encode methods for values outside 0 to
+// MaxIndex.
+func indexError(what string, n int) error {
+	return fmt.Errorf("%s %d out of range: IBX1 holds at most %d (0x%x)", what, n, MaxIndex, MaxIndex)
 }
 
-func (n Number) Encode() []byte {
+// Encode writes a number in 1, 2 or 3 bytes. Values below 0 or above
+// MaxIndex are an error.
+func (n Number) Encode() ([]byte, error) {
+	if n.Value < 0 || n.Value > MaxIndex {
+		return nil, indexError("number", n.Value)
+	}
 	var arr []byte
 	if n.Value < 0x40 {
 		arr = make([]byte, 1)
 		arr[0] = byte(n.Value)
-		return arr
+		return arr, nil
 	}
 	if n.Value < 0x100 {
 		arr = make([]byte, 2)
 		arr[0] = 0x40
 		arr[1] = byte(n.Value)
-		return arr
-	}
-	if n.Value < 0x10000 {
-		arr = make([]byte, 3)
-		arr[0] = 0x80
-		binary.BigEndian.PutUint16(arr[1:], uint16(n.Value))
-		return arr
-	}
-	if n.Value < 0x100000000 {
-		arr = make([]byte, 5)
-		arr[0] = 0xc0
-		binary.BigEndian.PutUint32(arr[1:], uint32(n.Value))
-		return arr
-	}
-	arr = make([]byte, 9)
-	arr[0] = 0xf0
-	binary.BigEndian.PutUint64(arr[1:], uint64(n.Value))
-	return arr
+		return arr, nil
+	}
+	arr = make([]byte, 3)
+	arr[0] = 0x80
+	binary.BigEndian.PutUint16(arr[1:], uint16(n.Value))
+	return arr, nil
 }
 
 func (n Number) Hex() string {
-	bs := n.Encode()
+	bs, err := n.Encode()
+	if err != nil {
+		return "[?]"
+	}
 	parts := make([]string, len(bs))
 	for i, b := range bs {
 		parts[i] = fmt.Sprintf("%02x", b)
@@ -58,97 +98,14 @@ func (n Number) Hex() string {
 	return fmt.Sprintf("[%s]", strings.Join(parts, " "))
 }
 
-func (v Int8) Encode() []byte {
-	if v.Value >= 0 && v.Value < 0x10 {
-		arr := make([]byte, 1)
-		arr[0] = byte(v.Value)
-		return arr
-	}
-	arr := make([]byte, 2)
-	arr[0] = 0x10
-	arr[1] = byte(v.Value)
-	return arr
-}
-
-func (v Int16) Encode() []byte {
-	arr := make([]byte, 3)
-	arr[0] = 0x20
-	binary.LittleEndian.PutUint16(arr[1:], uint16(v.Value))
-	return arr
-}
-
-func (v Int32) Encode() []byte {
-	arr := make([]byte, 5)
-	arr[0] = 0x30
-	binary.LittleEndian.PutUint32(arr[1:], uint32(v.Value))
-	return arr
-}
-
-func (v UInt8) Encode() []byte {
-	if v.Value >= 0 && v.Value < 0x10 {
-		arr := make([]byte, 1)
-		arr[0] = byte(v.Value)
-		return arr
-	}
-	arr := make([]byte, 2)
-	arr[0] = 0x10
-	arr[1] = byte(v.Value)
-	return arr
-}
-
-func (v UInt16) Encode() []byte {
-	arr := make([]byte, 3)
-	arr[0] = 0x20
-	binary.LittleEndian.PutUint16(arr[1:], v.Value)
-	return arr
-}
-
-func (v UInt32) Encode() []byte {
-	arr := make([]byte, 5)
-	arr[0] = 0x30
-	binary.LittleEndian.PutUint32(arr[1:], v.Value)
-	return arr
-}
-
-func (v Bool) Encode() []byte {
-	arr := make([]byte, 1)
-	if v.Value == false {
-		arr[0] = 0x40
-	} else {
-		arr[0] = 0x41
-	}
-	return arr
-}
-
-func (v Float) Encode() []byte {
-	var buf bytes.Buffer
-	buf.Write([]byte{0xb0})
-	binary.Write(&buf, binary.LittleEndian, v.Value)
-	return buf.Bytes()
-}
-
-func (v String) Encode() []byte {
-	if v.Value < 0x10 {
-		return []byte{byte(0xc0 + v.Value)}
-	}
-	if v.Value < 0x100 {
-		return []byte{0xd0, byte(v.Value)}
+// Encode writes the property. A name or value index outside 0 to
+// MaxIndex is an error.
+func (p *Property) Encode() ([]byte, error) {
+	if p.Name < 0 || p.Name > MaxIndex {
+		return nil, indexError("property name index", p.Name)
 	}
-	if v.Value < 0x10000 {
-		arr := make([]byte, 3)
-		arr[0] = 0xe0
-		binary.BigEndian.PutUint16(arr[1:], uint16(v.Value))
-		return arr
-	}
-	arr := make([]byte, 5)
-	arr[0] = 0xf0
-	binary.BigEndian.PutUint32(arr[1:], uint32(v.Value))
-	return arr
-}
-
-func (p *Property) Encode() []byte {
 	var buf bytes.Buffer
-	if p.Name+0x80 < 0xa0 {
+	if p.Name < 0x20 {
 		val := uint8(0x80 + p.Name)
 		binary.Write(&buf, binary.BigEndian, val)
 	} else if p.Name < 0x100 {
@@ -160,57 +117,193 @@ func (p *Property) Encode() []byte {
 		buf.Write([]byte("\xc0"))
 		binary.Write(&buf, binary.BigEndian, val)
 	}
-	v := Number{p.Value}
-	buf.Write(v.Encode())
-	return buf.Bytes()
+	v, err := Number{p.Value}.Encode()
+	if err != nil {
+		return nil, err
+	}
+	buf.Write(v)
+	return buf.Bytes(), nil
 }
 
-func (n *Node) Encode() []byte {
+// Encode writes the node and its subtree. An index or count outside 0 to
+// MaxIndex is an error.
+func (n *Node) Encode() ([]byte, error) {
 	var buf bytes.Buffer
 	buf.Write([]byte("\x00"))
-	name_index := Number{n.Name}
-	buf.Write(name_index.Encode())
-	np := Number{len(n.Properties)}
-	buf.Write(np.Encode())
-	nc := Number{len(n.Children)}
-	buf.Write(nc.Encode())
+	for _, num := range []int{n.Name, len(n.Properties), len(n.Children)} {
+		bs, err := Number{num}.Encode()
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(bs)
+	}
 	// props
 	for _, p := range n.Properties {
-		buf.Write(p.Encode())
+		bs, err := p.Encode()
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(bs)
 	}
 	// child nodes
 	for _, c := range n.Children {
-		buf.Write(c.Encode())
+		bs, err := c.Encode()
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(bs)
 	}
-	return buf.Bytes()
+	return buf.Bytes(), nil
 }
 
-func (d *Document) Encode() []byte {
+// checkLimits returns an error if an index or count in the document is
+// too large for the IBX1 encodings, or an index refers past its table.
+func (d *Document) checkLimits() error {
+	if len(d.Strings) > MaxIndex {
+		return indexError("number of strings", len(d.Strings))
+	}
+	if len(d.TypedValues) > MaxIndex {
+		return indexError("number of typed values", len(d.TypedValues))
+	}
+	for i, s := range d.Strings {
+		if len(s) > MaxIndex {
+			return fmt.Errorf("string #%d: %v", i, indexError("length", len(s)))
+		}
+	}
+	for i, tv := range d.TypedValues {
+		if tv == nil {
+			return fmt.Errorf("typed value #%d: unknown type", i)
+		}
+		if s, ok := tv.(String); ok {
+			if err := checkIndex("string index", s.Value, len(d.Strings), "strings"); err != nil {
+				return fmt.Errorf("typed value #%d: %v", i, err)
+			}
+		}
+	}
+	if d.Element == nil {
+		return errors.New("document has no root element")
+	}
+	var check func(n *Node) error
+	check = func(n *Node) error {
+		if err := checkIndex("element name index", n.Name, len(d.Strings), "strings"); err != nil {
+			return err
+		}
+		name := d.Strings[n.Name]
+		if len(n.Properties) > MaxIndex {
+			return fmt.Errorf("element %s: %v", name, indexError("number of properties", len(n.Properties)))
+		}
+		if len(n.Children) > MaxIndex {
+			return fmt.Errorf("element %s: %v", name, indexError("number of children", len(n.Children)))
+		}
+		for _, p := range n.Properties {
+			if err := checkIndex("property name index", p.Name, len(d.Strings), "strings"); err != nil {
+				return fmt.Errorf("element %s: %v", name, err)
+			}
+			if err := checkIndex("typed-value index", p.Value, len(d.TypedValues), "typed values"); err != nil {
+				return fmt.Errorf("element %s: property %s: %v", name, d.Strings[p.Name], err)
+			}
+		}
+		for _, c := range n.Children {
+			err := check(c)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return check(d.Element)
+}
+
+// checkIndex returns an error if index n can't be encoded or is not in a
+// table of size entries.
+func checkIndex(what string, n int, size int, table string) error {
+	if n < 0 || n > MaxIndex {
+		return indexError(what, n)
+	}
+	if n >= size {
+		return fmt.Errorf("%s %d out of range: the document has %d %s", what, n, size, table)
+	}
+	return nil
+}
+
+// Encode returns the document in IBX1 format. It fails if an index or
+// count is larger than MaxIndex.
+func (d *Document) Encode() ([]byte, error) {
+	err := d.checkLimits()
+	if err != nil {
+		return nil, err
+	}
+	// checkLimits has caught what the Encode methods below refuse, with
+	// better messages
 	var buf bytes.Buffer
 	buf.Write([]byte("IBX1"))
 	// strings
-	nstrings := Number{len(d.Strings)}
-	buf.Write(nstrings.Encode())
+	nstrings, _ := Number{len(d.Strings)}.Encode()
+	buf.Write(nstrings)
 	for _, s := range d.Strings {
-		n := Number{len(s)}
-		buf.Write(n.Encode())
+		n, _ := Number{len(s)}.Encode()
+		buf.Write(n)
 		buf.Write([]byte(s))
 		buf.Write([]byte("\x00"))
 	}
 	// typed values
-	nvals := Number{len(d.TypedValues)}
-	buf.Write(nvals.Encode())
-	for _, tv := range d.TypedValues {
-		buf.Write(tv.Encode())
+	nvals, _ := Number{len(d.TypedValues)}.Encode()
+	buf.Write(nvals)
+	for i, tv := range d.TypedValues {
+		bs, err := tv.Encode()
+		if err != nil {
+			return nil, fmt.Errorf("typed value #%d: %v", i, err)
+		}
+		buf.Write(bs)
 	}
 	// encoding flag
-	buf.Write([]byte("\x01"))
+	buf.WriteByte(d.encodingFlag())
 	// node structure
-	buf.Write(d.Element.Encode())
-	return buf.Bytes()
+	bs, err := d.Element.Encode()
+	if err != nil {
+		return nil, err
+	}
+	buf.Write(bs)
+	return buf.Bytes(), nil
 }
 
-func ReadNumber(reader *bufio.Reader) (*Number, error) {
+// ByteReader is the input of the Read functions. *bufio.Reader
+// satisfies it; wrap it in an OffsetReader to track byte offsets.
+type ByteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// OffsetReader counts the bytes consumed through it.
+type OffsetReader struct {
+	R      ByteReader
+	Offset int
+}
+
+func (r *OffsetReader) Read(p []byte) (int, error) {
+	n, err := r.R.Read(p)
+	r.Offset += n
+	return n, err
+}
+
+func (r *OffsetReader) ReadByte() (byte, error) {
+	b, err := r.R.ReadByte()
+	if err == nil {
+		r.Offset++
+	}
+	return b, err
+}
+
+// offsetOf returns the current offset of reader, or -1 if it is not
+// tracked.
+func offsetOf(reader ByteReader) int {
+	if r, ok := reader.(*OffsetReader); ok {
+		return r.Offset
+	}
+	return -1
+}
+
+func ReadNumber(reader ByteReader) (*Number, error) {
 	b, err := reader.ReadByte()
 	if err != nil {
 		return nil, err
@@ -236,100 +329,19 @@ func ReadNumber(reader *bufio.Reader) (*Number, error) {
 	return nil, fmt.Errorf("unknown number encoding")
 }
 
-func ReadTypedValue(reader *bufio.Reader) (TypedValue, error) {
-	b, err := reader.ReadByte()
-	if err != nil {
-		return nil, err
-	}
-	if b < 0x10 {
-		return Int8{int8(b)}, nil
-	}
-	if b == 0x10 {
-		v, err := reader.ReadByte()
-		if err != nil {
-			return nil, err
-		}
-		return Int8{int8(v)}, nil
-	}
-	if b == 0x20 {
-		bs := make([]byte, 2)
-		_, err := io.ReadFull(reader, bs)
-		if err != nil {
-			return nil, err
-		}
-		v := binary.LittleEndian.Uint16(bs)
-		return Int16{int16(v)}, nil
-	}
-	if b == 0x30 {
-		bs := make([]byte, 4)
-		_, err := io.ReadFull(reader, bs)
-		if err != nil {
-			return nil, err
-		}
-		v := binary.LittleEndian.Uint32(bs)
-		return Int32{int32(v)}, nil
-	}
-	if b == 0x40 {
-		return Bool{false}, nil
-	}
-	if b == 0x41 {
-		return Bool{true}, nil
-	}
-	if b == 0xb0 {
-		bs := make([]byte, 4)
-		_, err := io.ReadFull(reader, bs)
-		if err != nil {
-			return nil, err
-		}
-		var v float32
-		buf := bytes.NewReader(bs)
-		err = binary.Read(buf, binary.LittleEndian, &v)
-		if err != nil {
-			return nil, err
-		}
-		return Float{v}, nil
-	}
-	if b >= 0xc0 && b < 0xd0 {
-		v := int(b) - 0xc0
-		return String{v}, nil
-	}
-	if b == 0xd0 {
-		v, err := reader.ReadByte()
-		if err != nil {
-			return nil, err
-		}
-		return String{int(v)}, nil
-	}
-	if b == 0xe0 {
-		bs := make([]byte, 2)
-		_, err := io.ReadFull(reader, bs)
-		if err != nil {
-			return nil, err
-		}
-		var v uint16
-		buf := bytes.NewReader(bs)
-		err = binary.Read(buf, binary.BigEndian, &v)
-		if err != nil {
-			return nil, err
-		}
-		return String{int(v)}, nil
-	}
-
-	return nil, nil //fmt.Errorf("unknown typed-value encoding")
-}
-
-func ReadProperty(reader *bufio.Reader) (*Property, error) {
+func ReadProperty(reader ByteReader) (*Property, error) {
+	offset := offsetOf(reader)
 	b, err := reader.ReadByte()
 	if err != nil {
 		return nil, err
 	}
-	if b < 0xa0 {
+	if b >= 0x80 && b < 0xa0 {
 		nameIndex := int(b) - 0x80
 		v, err := ReadNumber(reader)
 		if err != nil {
 			return nil, err
 		}
-		return &Property{Name: nameIndex, Value: v.Value}, nil
+		return &Property{Name: nameIndex, Value: v.Value, Offset: offset}, nil
 	}
 	if b == 0xa0 {
 		v, err := reader.ReadByte()
@@ -341,7 +353,7 @@ func ReadProperty(reader *bufio.Reader) (*Property, error) {
 		if err != nil {
 			return nil, err
 		}
-		return &Property{Name: nameIndex, Value: val.Value}, nil
+		return &Property{Name: nameIndex, Value: val.Value, Offset: offset}, nil
 	}
 	if b == 0xc0 {
 		bs := make([]byte, 2)
@@ -360,12 +372,13 @@ func ReadProperty(reader *bufio.Reader) (*Property, error) {
 		if err != nil {
 			return nil, err
 		}
-		return &Property{Name: nameIndex, Value: val.Value}, nil
+		return &Property{Name: nameIndex, Value: val.Value, Offset: offset}, nil
 	}
 	return nil, fmt.Errorf("unknown property type")
 }
 
-func ReadNode(reader *bufio.Reader) (*Node, error) {
+func ReadNode(reader ByteReader) (*Node, error) {
+	offset := offsetOf(reader)
 	b, err := reader.ReadByte()
 	if err != nil {
 		return nil, err
@@ -385,7 +398,7 @@ func ReadNode(reader *bufio.Reader) (*Node, error) {
 	if err != nil {
 		return nil, err
 	}
-	node := &Node{Name: nameIndex.Value}
+	node := &Node{Name: nameIndex.Value, Offset: offset}
 	for i := 0; i < numProps.Value; i++ {
 		p, err := ReadProperty(reader)
 		if err != nil {
@@ -403,56 +416,110 @@ func ReadNode(reader *bufio.Reader) (*Node, error) {
 	return node, nil
 }
 
-func (d *Document) GetTypeAndValue(val TypedValue, options *Options) (string, string) {
-	switch val.(type) {
-	case String:
-		typeId := val.TypeId()
-		if typeId >= 0xc0 && typeId < 0xd0 {
-			v := typeId - 0xc0
-			return "string", d.Strings[v]
-		} else if typeId == 0xd0 || typeId == 0xe0 {
-			v := val.(String)
-			return "string", d.Strings[v.Value]
-		}
-	case Float:
-		v := val.(Float)
-		return "float", fmt.Sprintf("%f", v.Value)
-	case Bool:
-		v := val.(Bool)
-		if v.Value {
-			return "bool", "true"
-		}
-		return "bool", "false"
-	case Int8:
-		v := val.(Int8)
-		if options.Hex8 {
-			return "int8", fmt.Sprintf("0x%X", uint8(v.Value))
-		}
-		return "int8", fmt.Sprintf("%d", v.Value)
-	case Int16:
-		v := val.(Int16)
-		if options.Hex16 {
-			return "int16", fmt.Sprintf("0x%X", uint16(v.Value))
-		}
-		return "int16", fmt.Sprintf("%d", v.Value)
-	case Int32:
-		v := val.(Int32)
-		if options.Hex32 {
-			return "int32", fmt.Sprintf("0x%X", uint32(v.Value))
-		}
-		return "int32", fmt.Sprintf("%d", v.Value)
-	}
-	return "_?_", "_?_"
+// ReadDocument reads a complete IBX1 file, starting with the signature.
+// Offsets of nodes and properties are recorded relative to the start of
+// the reader.
+func ReadDocument(r *bufio.Reader, options *Options) (*Document, error) {
+	reader := &OffsetReader{R: r}
+	sig := make([]byte, 4)
+	_, err := io.ReadFull(reader, sig)
+	if err != nil {
+		return nil, fmt.Errorf("reading signature: %v", err)
+	}
+	if string(sig) != "IBX1" {
+		return nil, fmt.Errorf("not an IBX1 file")
+	}
+	doc := &Document{}
+	// num strings
+	numStrings, err := ReadNumber(reader)
+	if err != nil {
+		return nil, fmt.Errorf("reading number of strings: %v", err)
+	}
+	if options.Debug {
+		fmt.Printf("number of strings: 0x%x (%d)\n", numStrings.Value, numStrings.Value)
+	}
+	// strings
+	for i := 0; i < numStrings.Value; i++ {
+		n, err := ReadNumber(reader)
+		if err != nil {
+			return nil, fmt.Errorf("reading string length: %v", err)
+		}
+		bs := make([]byte, n.Value)
+		_, err = io.ReadFull(reader, bs)
+		if err != nil {
+			return nil, fmt.Errorf("reading string: %v", err)
+		}
+		_, err = reader.ReadByte() // 0-terminator
+		if err != nil {
+			return nil, fmt.Errorf("reading string 0-terminator: %v", err)
+		}
+
+		if options.Debug {
+			fmt.Printf("0x%x (%d): 0x%x {%s}\n", i, i, n.Value, string(bs))
+		}
+		doc.Strings = append(doc.Strings, string(bs))
+	}
+	// num typed values
+	numTypedValues, err := ReadNumber(reader)
+	if err != nil {
+		return nil, fmt.Errorf("reading number of typed values: %v", err)
+	}
+	if options.Debug {
+		fmt.Printf("number of typed values: 0x%x (%d)\n", numTypedValues.Value, numTypedValues.Value)
+	}
+	// typed values
+	for i := 0; i < numTypedValues.Value; i++ {
+		tv, err := ReadTypedValue(reader)
+		if err != nil {
+			return nil, fmt.Errorf("reading typed value: %v", err)
+		}
+
+		if options.Debug {
+			fmt.Printf("0x%x (%d): %v\n", i, i, tv)
+		}
+		doc.TypedValues = append(doc.TypedValues, tv)
+	}
+	// encoding flag
+	doc.EncodingFlag, err = reader.ReadByte()
+	if err != nil {
+		return nil, fmt.Errorf("reading encoding flag: %v", err)
+	}
+	err = checkEncodingFlag(doc.EncodingFlag, options)
+	if err != nil {
+		return nil, err
+	}
+	// node structure
+	node, err := ReadNode(reader)
+	if err != nil {
+		return nil, fmt.Errorf("reading node structure: %v", err)
+	}
+	doc.Element = node
+	doc.attach(node)
+
+	if options.Debug {
+		fmt.Printf("%v\n", *doc)
+	}
+	return doc, nil
+}
+
+// typedValue returns the typed value at index i, or nil if there is none.
+func (d *Document) typedValue(i int) TypedValue {
+	if i < 0 || i >= len(d.TypedValues) {
+		return nil
+	}
+	return d.TypedValues[i]
 }
 
 func (d *Document) WriteProperty(enc *xml.Encoder, prop *Property, options *Options) error {
 	name := d.Strings[prop.Name]
-	typ, val := d.GetTypeAndValue(d.TypedValues[prop.Value], options)
+	tv := d.typedValue(prop.Value)
+	typ, val := d.GetTypeAndValue(tv, options)
+	val = EscapeVariables(val)
 
 	t := xml.StartElement{
 		Name: xml.Name{Local: "property"},
 		Attr: []xml.Attr{
-			xml.Attr{Name: xml.Name{Local: "name"}, Value: name},
+			xml.Attr{Name: xml.Name{Local: "name"}, Value: EncodeString(name)},
 			xml.Attr{Name: xml.Name{Local: "type"}, Value: typ},
 			xml.Attr{Name: xml.Name{Local: "value"}, Value: val},
 		},
@@ -465,6 +532,24 @@ func (d *Document) WriteProperty(enc *xml.Encoder, prop *Property, options *Opti
 	if err != nil {
 		return err
 	}
+	if options.Annotate {
+		text := fmt.Sprintf(" ibx: name=#%d value=#%d type=", prop.Name, prop.Value)
+		if tv != nil {
+			text += fmt.Sprintf("0x%02x", tv.TypeId())
+		} else {
+			text += "?"
+		}
+		if prop.Offset >= 0 {
+			text += fmt.Sprintf(" offset=0x%x", prop.Offset)
+		}
+		if prop.Value >= 0 && prop.Value < len(options.valueRefs) {
+			text += fmt.Sprintf(" shared=%d", options.valueRefs[prop.Value])
+		}
+		err := enc.EncodeToken(xml.Comment(text + " "))
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -472,12 +557,50 @@ func (d *Document) WriteNode(enc *xml.Encoder, node *Node, options *Options) err
 	// start tag
 	name := d.Strings[node.Name]
 	t := xml.StartElement{Name: xml.Name{Local: name}}
+	seen := make(map[string]bool)
+	if !isXMLName(name) || name == nodeTag || name == "property" {
+		// fallback form for names that can't be used as tags
+		t = xml.StartElement{
+			Name: xml.Name{Local: nodeTag},
+			Attr: []xml.Attr{
+				xml.Attr{Name: xml.Name{Local: "name"}, Value: EncodeString(name)},
+			},
+		}
+		seen["name"] = true
+	}
+	props := node.Properties
+	if options.Compact {
+		// leading properties become attributes, as long as their
+		// names are usable as attribute names and unique
+		n := 0
+		for _, p := range props {
+			pname := d.Strings[p.Name]
+			if !isXMLName(pname) || seen[pname] {
+				break
+			}
+			seen[pname] = true
+			typ, val := d.GetTypeAndValue(d.typedValue(p.Value), options)
+			t.Attr = append(t.Attr, xml.Attr{Name: xml.Name{Local: pname}, Value: typ + ":" + EscapeVariables(val)})
+			n++
+		}
+		props = props[n:]
+	}
 	err := enc.EncodeToken(t)
 	if err != nil {
 		return err
 	}
+	if options.Annotate {
+		text := fmt.Sprintf(" ibx: name=#%d", node.Name)
+		if node.Offset >= 0 {
+			text += fmt.Sprintf(" offset=0x%x", node.Offset)
+		}
+		err = enc.EncodeToken(xml.Comment(text + " "))
+		if err != nil {
+			return err
+		}
+	}
 	// child nodes
-	for _, p := range node.Properties {
+	for _, p := range props {
 		d.WriteProperty(enc, p, options)
 	}
 	for _, c := range node.Children {
@@ -490,3 +613,32 @@ func (d *Document) WriteNode(enc *xml.Encoder, node *Node, options *Options) err
 	}
 	return nil
 }
+
+// WriteXML writes the document as XML, including the XML declaration.
+func (d *Document) WriteXML(w io.Writer, options *Options) error {
+	writer := bufio.NewWriter(w)
+	_, err := writer.WriteString("<?xml version=\"1.0\" ?>\n")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(writer, "<?%s encoding-flag=\"0x%02x\"?>\n", headerTarget, d.encodingFlag())
+	if err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(writer)
+	enc.Indent("", "  ")
+	if options.Annotate {
+		annotate := *options
+		annotate.valueRefs = d.ValueRefs()
+		options = &annotate
+	}
+	err = d.WriteNode(enc, d.Element, options)
+	if err != nil {
+		return err
+	}
+	err = enc.Flush()
+	if err != nil {
+		return err
+	}
+	return writer.Flush()
+}
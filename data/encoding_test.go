@@ -0,0 +1,270 @@
+package data
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// mustFail fails the test unless an Encode method returned an out of
+// range error.
+func mustFail(t *testing.T, what string, bs []byte, err error) {
+	t.Helper()
+	if err == nil || !strings.Contains(err.Error(), "out of range") {
+		t.Errorf("%s: got % x, %v, want out of range error", what, bs, err)
+	}
+}
+
+// encodeNumber returns the encoding of a number that can be encoded.
+func encodeNumber(t *testing.T, n int) []byte {
+	t.Helper()
+	bs, err := Number{n}.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bs
+}
+
+func TestNumberEncoding(t *testing.T) {
+	tests := []struct {
+		value int
+		first byte
+		size  int
+	}{
+		{0, 0x00, 1},
+		{0x3f, 0x3f, 1},
+		{0x40, 0x40, 2},
+		{0xff, 0x40, 2},
+		{0x100, 0x80, 3},
+		{0xffff, 0x80, 3},
+	}
+	for _, test := range tests {
+		bs := encodeNumber(t, test.value)
+		if len(bs) != test.size || bs[0] != test.first {
+			t.Errorf("number 0x%x: got % x, want %d bytes starting with %02x", test.value, bs, test.size, test.first)
+			continue
+		}
+		n, err := ReadNumber(bytes.NewReader(bs))
+		if err != nil {
+			t.Errorf("number 0x%x: %v", test.value, err)
+		} else if n.Value != test.value {
+			t.Errorf("number 0x%x: read back 0x%x", test.value, n.Value)
+		}
+	}
+	bs, err := Number{0x10000}.Encode()
+	mustFail(t, "number 0x10000", bs, err)
+	bs, err = Number{-1}.Encode()
+	mustFail(t, "number -1", bs, err)
+}
+
+func TestPropertyEncoding(t *testing.T) {
+	names := []struct {
+		index int
+		first byte
+		size  int
+	}{
+		{0, 0x80, 1},
+		{0x1f, 0x9f, 1},
+		{0x20, 0xa0, 2},
+		{0xff, 0xa0, 2},
+		{0x100, 0xc0, 3},
+		{0xffff, 0xc0, 3},
+	}
+	values := []int{0, 0x3f, 0x40, 0xff, 0x100, 0xffff}
+	for _, name := range names {
+		for _, value := range values {
+			p := &Property{Name: name.index, Value: value}
+			bs, err := p.Encode()
+			if err != nil {
+				t.Errorf("property 0x%x=0x%x: %v", name.index, value, err)
+				continue
+			}
+			want := name.size + len(encodeNumber(t, value))
+			if len(bs) != want || bs[0] != name.first {
+				t.Errorf("property 0x%x=0x%x: got % x, want %d bytes starting with %02x", name.index, value, bs, want, name.first)
+				continue
+			}
+			q, err := ReadProperty(bytes.NewReader(bs))
+			if err != nil {
+				t.Errorf("property 0x%x=0x%x: %v", name.index, value, err)
+			} else if q.Name != name.index || q.Value != value {
+				t.Errorf("property 0x%x=0x%x: read back 0x%x=0x%x", name.index, value, q.Name, q.Value)
+			}
+		}
+	}
+	bs, err := (&Property{Name: 0x10000}).Encode()
+	mustFail(t, "property name 0x10000", bs, err)
+	bs, err = (&Property{Value: 0x10000}).Encode()
+	mustFail(t, "property value 0x10000", bs, err)
+}
+
+func TestStringRefEncoding(t *testing.T) {
+	tests := []struct {
+		index int
+		first byte
+		size  int
+	}{
+		{0, 0xc0, 1},
+		{0xf, 0xcf, 1},
+		{0x10, 0xd0, 2},
+		{0xff, 0xd0, 2},
+		{0x100, 0xe0, 3},
+		{0xffff, 0xe0, 3},
+	}
+	for _, test := range tests {
+		bs, err := String{test.index}.Encode()
+		if err != nil {
+			t.Errorf("string ref 0x%x: %v", test.index, err)
+			continue
+		}
+		if len(bs) != test.size || bs[0] != test.first {
+			t.Errorf("string ref 0x%x: got % x, want %d bytes starting with %02x", test.index, bs, test.size, test.first)
+			continue
+		}
+		tv, err := ReadTypedValue(bytes.NewReader(bs))
+		if err != nil {
+			t.Errorf("string ref 0x%x: %v", test.index, err)
+		} else if tv != (String{test.index}) {
+			t.Errorf("string ref 0x%x: read back %v", test.index, tv)
+		}
+	}
+	bs, err := String{0x10000}.Encode()
+	mustFail(t, "string ref 0x10000", bs, err)
+	bs, err = String{-1}.Encode()
+	mustFail(t, "string ref -1", bs, err)
+}
+
+// roundTrip encodes d and reads it back.
+func roundTrip(d *Document) (*Document, error) {
+	bs, err := d.Encode()
+	if err != nil {
+		return nil, err
+	}
+	return ReadDocument(bufio.NewReader(bytes.NewReader(bs)), &Options{})
+}
+
+func TestDocumentLimits(t *testing.T) {
+	tests := []struct {
+		name  string
+		err   string // the error above MaxIndex
+		build func(d *Document, n int)
+		check func(d *Document, n int) bool
+	}{
+		{
+			"strings", "number of strings",
+			func(d *Document, n int) {
+				for len(d.Strings) < n {
+					d.Strings = append(d.Strings, fmt.Sprintf("s%d", len(d.Strings)))
+				}
+			},
+			func(d *Document, n int) bool { return len(d.Strings) == n },
+		},
+		{
+			"string length", "length",
+			func(d *Document, n int) { d.Element.SetString("long", strings.Repeat("x", n)) },
+			func(d *Document, n int) bool {
+				_, val, _ := d.Element.Text("long")
+				return len(val) == n
+			},
+		},
+		{
+			"typed values", "number of typed values",
+			func(d *Document, n int) {
+				for len(d.TypedValues) < n {
+					d.TypedValues = append(d.TypedValues, Int32{int32(len(d.TypedValues))})
+				}
+			},
+			func(d *Document, n int) bool { return len(d.TypedValues) == n },
+		},
+		{
+			"properties", "number of properties",
+			func(d *Document, n int) {
+				d.Element.SetInt8("p", 1)
+				for len(d.Element.Properties) < n {
+					d.Element.Properties = append(d.Element.Properties, &Property{Name: 1, Value: 0})
+				}
+			},
+			func(d *Document, n int) bool { return len(d.Element.Properties) == n },
+		},
+		{
+			"children", "number of children",
+			func(d *Document, n int) {
+				for len(d.Element.Children) < n {
+					d.Element.AddChild(d.NewNode("Child"))
+				}
+			},
+			func(d *Document, n int) bool { return len(d.Element.Children) == n },
+		},
+		{
+			"string ref", "string index",
+			func(d *Document, n int) {
+				d.GetString("ref")
+				if n > MaxIndex {
+					// a table that large fails first, so leave it out
+					d.Element.Set("ref", String{n})
+					return
+				}
+				for len(d.Strings) < n {
+					d.Strings = append(d.Strings, fmt.Sprintf("s%d", len(d.Strings)))
+				}
+				d.Element.Set("ref", String{n - 1})
+			},
+			func(d *Document, n int) bool {
+				_, val, _ := d.Element.Text("ref")
+				return val == fmt.Sprintf("s%d", n-1)
+			},
+		},
+	}
+	for _, test := range tests {
+		for _, n := range []int{0x3f, 0x40, 0xff, 0x100, MaxIndex} {
+			d := NewDocument()
+			d.Element = d.NewNode("Root")
+			test.build(d, n)
+			r, err := roundTrip(d)
+			if err != nil {
+				t.Errorf("%s 0x%x: %v", test.name, n, err)
+			} else if !test.check(r, n) {
+				t.Errorf("%s 0x%x: not read back", test.name, n)
+			}
+		}
+		d := NewDocument()
+		d.Element = d.NewNode("Root")
+		test.build(d, MaxIndex+1)
+		_, err := d.Encode()
+		if err == nil || !strings.Contains(err.Error(), test.err+" ") {
+			t.Errorf("%s 0x%x: got error %v, want %s out of range", test.name, MaxIndex+1, err, test.err)
+		}
+	}
+}
+
+func TestDocumentReferences(t *testing.T) {
+	tests := []struct {
+		name  string
+		build func(d *Document)
+		want  string
+	}{
+		{"no root", func(d *Document) { d.Element = nil }, "no root element"},
+		{"element name", func(d *Document) { d.Element.Name = 5 }, "element name index 5 out of range: the document has 1 strings"},
+		{"property name", func(d *Document) {
+			d.Element.SetInt8("a", 1)
+			d.Element.Properties[0].Name = 9
+		}, "element Root: property name index 9 out of range"},
+		{"property value", func(d *Document) {
+			d.Element.SetInt8("a", 1)
+			d.Element.Properties[0].Value = 3
+		}, "element Root: property a: typed-value index 3 out of range: the document has 1 typed values"},
+		{"string value", func(d *Document) { d.Element.Set("a", String{7}) }, "typed value #0: string index 7 out of range"},
+		{"unknown type", func(d *Document) { d.TypedValues = append(d.TypedValues, nil) }, "typed value #0: unknown type"},
+	}
+	for _, test := range tests {
+		d := NewDocument()
+		d.Element = d.NewNode("Root")
+		test.build(d)
+		bs, err := d.Encode()
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got % x, %v, want error %q", test.name, bs, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package data
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+)
+
+type FileType int
+
+const (
+	FileBinary FileType = iota
+	FileEmpty
+	FileIBX1
+	FileXML
+	FilePlainXML
+	FileFragment
+)
+
+func (t FileType) String() string {
+	switch t {
+	case FileEmpty:
+		return "empty"
+	case FileIBX1:
+		return "IBX1"
+	case FileXML:
+		return "XML"
+	case FilePlainXML:
+		return "plain XML"
+	case FileFragment:
+		return "include fragment"
+	}
+	return "unknown binary"
+}
+
+// What to do with files that cannot be converted: plain XML DATs,
+// empty files and unknown binaries.
+const (
+	PassThroughCopy  = "copy"
+	PassThroughSkip  = "skip"
+	PassThroughError = "error"
+)
+
+// DetectFileType classifies a file by its first bytes. XML is recognized
+// by a leading '<' after an optional UTF-8 BOM and whitespace; telling the
+// IBX1 XML dialect from other XML needs a full parse, so FileXML is
+// returned for both.
+func DetectFileType(head []byte) FileType {
+	if len(head) == 0 {
+		return FileEmpty
+	}
+	if bytes.HasPrefix(head, []byte("IBX1")) {
+		return FileIBX1
+	}
+	text := bytes.TrimPrefix(head, []byte("\xef\xbb\xbf"))
+	text = bytes.TrimLeft(text, " \t\r\n")
+	if len(text) > 0 && text[0] == '<' {
+		return FileXML
+	}
+	return FileBinary
+}
+
+// Summary collects what happened to each file during a run.
+type Summary struct {
+	Converted int
+	Failed    int
+	Copied    map[FileType][]string
+	Skipped   map[FileType][]string
+}
+
+func (s *Summary) AddCopied(typ FileType, name string) {
+	if s.Copied == nil {
+		s.Copied = make(map[FileType][]string)
+	}
+	s.Copied[typ] = append(s.Copied[typ], name)
+}
+
+func (s *Summary) AddSkipped(typ FileType, name string) {
+	if s.Skipped == nil {
+		s.Skipped = make(map[FileType][]string)
+	}
+	s.Skipped[typ] = append(s.Skipped[typ], name)
+}
+
+func (s *Summary) Print() {
+	fmt.Printf("  converted: %d\n", s.Converted)
+	printFileLists("passed through unchanged", s.Copied)
+	printFileLists("skipped", s.Skipped)
+	if s.Failed > 0 {
+		fmt.Printf("  failed: %d\n", s.Failed)
+	}
+}
+
+func printFileLists(label string, lists map[FileType][]string) {
+	var types []int
+	for typ := range lists {
+		types = append(types, int(typ))
+	}
+	sort.Ints(types)
+	for _, typ := range types {
+		names := lists[FileType(typ)]
+		fmt.Printf("  %s (%v): %d\n", label, FileType(typ), len(names))
+		for _, name := range names {
+			fmt.Printf("    %s\n", name)
+		}
+	}
+}
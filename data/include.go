@@ -0,0 +1,197 @@
+package data
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// IncludeNamespace is the namespace of the include directives. The ibx
+// prefix may also be used without declaring it.
+const IncludeNamespace = "urn:fifa-ibx1"
+
+// ErrFragment is returned by ReadXML for files whose root element is
+// <ibx:fragment>: they are only meant to be included by other files.
+var ErrFragment = errors.New("include fragment")
+
+// xmlSource is where ReadXML gets its tokens from. It expands
+// <ibx:include href="..."/> directives in place with the elements of the
+// included file, and drops <ibx:fragment> wrappers, so that the document
+// is the same as if the fragments had been pasted in by hand.
+type xmlSource struct {
+	frames   []*xmlFrame
+	includes []string
+}
+
+type xmlFrame struct {
+	name  string // file name, or "" for an unnamed reader
+	dir   string // directory that hrefs are relative to
+	bs    []byte
+	dec   *xml.Decoder
+	depth int // elements open in this file
+}
+
+func newXMLSource(bs []byte, name string) *xmlSource {
+	s := &xmlSource{}
+	dir := "."
+	if name != "" {
+		dir = filepath.Dir(name)
+	}
+	s.push(bs, name, dir)
+	return s
+}
+
+func (s *xmlSource) push(bs []byte, name string, dir string) {
+	s.frames = append(s.frames, &xmlFrame{name: name, dir: dir, bs: bs, dec: xml.NewDecoder(bytes.NewReader(bs))})
+}
+
+func (s *xmlSource) top() *xmlFrame {
+	return s.frames[len(s.frames)-1]
+}
+
+// included reports whether tokens currently come from an included file.
+func (s *xmlSource) included() bool {
+	return len(s.frames) > 1
+}
+
+// location returns the file and line of the current token, like
+// "fragments/tv_cam.xml:12", or "line 12" for an unnamed reader.
+func (s *xmlSource) location() string {
+	f := s.top()
+	offset := int(f.dec.InputOffset())
+	if offset > len(f.bs) {
+		offset = len(f.bs)
+	}
+	line := bytes.Count(f.bs[:offset], []byte("\n")) + 1
+	if f.name == "" {
+		return fmt.Sprintf("line %d", line)
+	}
+	return fmt.Sprintf("%s:%d", f.name, line)
+}
+
+func (s *xmlSource) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%s: %s", s.location(), fmt.Sprintf(format, args...))
+}
+
+// notIBX1 is the error for elements that are not in the IBX1 XML dialect.
+// Files whose root is such an element are plain XML, but included files
+// must be in the dialect.
+func (s *xmlSource) notIBX1() error {
+	if s.included() {
+		return s.errorf("%v", ErrPlainXML)
+	}
+	return ErrPlainXML
+}
+
+func isDirective(name xml.Name, local string) bool {
+	return (name.Space == "ibx" || name.Space == IncludeNamespace) && name.Local == local
+}
+
+// Token returns the next token, reading included files as it goes.
+func (s *xmlSource) Token() (xml.Token, error) {
+	for {
+		f := s.top()
+		tok, err := f.dec.Token()
+		if err == io.EOF && s.included() {
+			s.frames = s.frames[:len(s.frames)-1]
+			continue
+		} else if err != nil {
+			if s.included() {
+				return nil, fmt.Errorf("%s: %v", f.name, err)
+			}
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch {
+			case isDirective(t.Name, "fragment"):
+				if !s.included() && f.depth == 0 {
+					return nil, ErrFragment
+				}
+				continue
+			case isDirective(t.Name, "include"):
+				err = s.include(t)
+				if err != nil {
+					return nil, err
+				}
+				continue
+			case t.Name.Space == "ibx" || t.Name.Space == IncludeNamespace:
+				return nil, s.errorf("unknown directive <ibx:%s>", t.Name.Local)
+			}
+			f.depth++
+		case xml.EndElement:
+			if isDirective(t.Name, "fragment") {
+				continue
+			}
+			f.depth--
+		}
+		return tok, nil
+	}
+}
+
+// include reads the file named by an <ibx:include> directive and makes
+// it the source of the following tokens.
+func (s *xmlSource) include(t xml.StartElement) error {
+	var href string
+	for _, a := range t.Attr {
+		if a.Name.Local == "href" {
+			href = a.Value
+		} else {
+			return s.errorf("<ibx:include> has unknown attribute %s", a.Name.Local)
+		}
+	}
+	if href == "" {
+		return s.errorf("<ibx:include> without href")
+	}
+	// the directive must be empty
+	f := s.top()
+	tok, err := f.dec.Token()
+	for err == nil {
+		if end, ok := tok.(xml.EndElement); ok && isDirective(end.Name, "include") {
+			break
+		}
+		if cd, ok := tok.(xml.CharData); !ok || len(strings.TrimSpace(string(cd))) > 0 {
+			return s.errorf("<ibx:include> must be empty")
+		}
+		tok, err = f.dec.Token()
+	}
+	if err != nil {
+		return s.errorf("%v", err)
+	}
+
+	name := filepath.FromSlash(strings.Replace(href, "\\", "/", -1))
+	if !filepath.IsAbs(name) {
+		name = filepath.Join(f.dir, name)
+	}
+	for _, g := range s.frames {
+		if g.name != "" && sameFile(g.name, name) {
+			var chain []string
+			for _, h := range s.frames {
+				if h.name == "" {
+					chain = append(chain, "-")
+				} else {
+					chain = append(chain, h.name)
+				}
+			}
+			return s.errorf("include cycle: %s -> %s", strings.Join(chain, " -> "), name)
+		}
+	}
+	bs, err := ioutil.ReadFile(name)
+	if err != nil {
+		return s.errorf("including %s: %v", href, err)
+	}
+	s.includes = append(s.includes, name)
+	s.push(bs, name, filepath.Dir(name))
+	return nil
+}
+
+func sameFile(a string, b string) bool {
+	a, errA := filepath.Abs(a)
+	b, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && a == b
+}
@@ -0,0 +1,130 @@
+package data
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeFiles writes files, named by slash-separated paths, into a new
+// temporary directory and returns it.
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "ibx1-include")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, text := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// readFile reads the named XML file with ReadXMLNamed.
+func readFile(t *testing.T, name string) (*Document, error) {
+	t.Helper()
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	return ReadXMLNamed(f, name, &Options{})
+}
+
+func TestInclude(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"main.xml": `<Root xmlns:ibx="urn:fifa-ibx1" a="int8:1">
+  <ibx:include href="fragments/cams.xml"/>
+  <Last b="string:end"/>
+</Root>`,
+		"fragments/cams.xml": `<ibx:fragment>
+  <Cam name="string:wide"/>
+  <Cam name="string:close">
+    <ibx:include href="mods.xml" />
+  </Cam>
+</ibx:fragment>`,
+		"fragments/mods.xml": `<Mod zoom="float:0.5"/>`,
+	})
+	defer os.RemoveAll(dir)
+
+	doc, err := readFile(t, filepath.Join(dir, "main.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := ReadXML(strings.NewReader(`<Root a="int8:1">
+  <Cam name="string:wide"/>
+  <Cam name="string:close"><Mod zoom="float:0.5"/></Cam>
+  <Last b="string:end"/>
+</Root>`), &Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.Element.String() != want.Element.String() || !reflect.DeepEqual(doc.Strings, want.Strings) {
+		t.Errorf("got %v %q, want %v %q", doc.Element, doc.Strings, want.Element, want.Strings)
+	}
+	includes := []string{
+		filepath.Join(dir, "fragments", "cams.xml"),
+		filepath.Join(dir, "fragments", "mods.xml"),
+	}
+	if !reflect.DeepEqual(doc.Includes, includes) {
+		t.Errorf("includes: got %q, want %q", doc.Includes, includes)
+	}
+
+	if _, err := readFile(t, filepath.Join(dir, "fragments", "cams.xml")); err != ErrFragment {
+		t.Errorf("reading a fragment: got %v, want ErrFragment", err)
+	}
+	// a fragment with a single element can be read on its own
+	if _, err := readFile(t, filepath.Join(dir, "fragments", "mods.xml")); err != nil {
+		t.Errorf("reading an unwrapped fragment: %v", err)
+	}
+}
+
+func TestIncludeErrors(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"self.xml":    `<Root><ibx:include href="self.xml"/></Root>`,
+		"cycle.xml":   `<Root><ibx:include href="a.xml"/></Root>`,
+		"a.xml":       `<ibx:fragment><A/><ibx:include href="sub/b.xml"/></ibx:fragment>`,
+		"sub/b.xml":   `<B><ibx:include href="../a.xml"/></B>`,
+		"missing.xml": `<Root><ibx:include href="nothere.xml"/></Root>`,
+		"plain.xml":   `<Root><ibx:include href="text.xml"/></Root>`,
+		"text.xml":    `<p>text</p>`,
+		"unknown.xml": `<Root><ibx:import href="a.xml"/></Root>`,
+		"nohref.xml":  `<Root><ibx:include/></Root>`,
+		"body.xml":    `<Root><ibx:include href="text.xml"><A/></ibx:include></Root>`,
+		"attr.xml":    `<Root><ibx:include href="text.xml" parse="xml"/></Root>`,
+	})
+	defer os.RemoveAll(dir)
+
+	path := func(name string) string {
+		return filepath.Join(dir, filepath.FromSlash(name))
+	}
+	tests := []struct {
+		file string
+		want string
+	}{
+		{"self.xml", "include cycle: " + path("self.xml") + " -> " + path("self.xml")},
+		{"cycle.xml", path("sub/b.xml") + ":1: include cycle: " +
+			path("cycle.xml") + " -> " + path("a.xml") + " -> " + path("sub/b.xml") + " -> " + path("a.xml")},
+		{"missing.xml", "including nothere.xml"},
+		{"plain.xml", path("text.xml") + ":1: " + ErrPlainXML.Error()},
+		{"unknown.xml", "unknown directive <ibx:import>"},
+		{"nohref.xml", "<ibx:include> without href"},
+		{"body.xml", "<ibx:include> must be empty"},
+		{"attr.xml", "<ibx:include> has unknown attribute parse"},
+	}
+	for _, test := range tests {
+		_, err := readFile(t, path(test.file))
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got %v, want %q", test.file, err, test.want)
+		}
+	}
+}
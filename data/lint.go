@@ -0,0 +1,263 @@
+package data
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type Severity int
+
+const (
+	SeverityInfo Severity = iota
+	SeverityWarning
+	SeverityError
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityInfo:
+		return "info"
+	case SeverityWarning:
+		return "warning"
+	case SeverityError:
+		return "error"
+	}
+	return "unknown"
+}
+
+// ParseSeverity returns the severity with the given name.
+func ParseSeverity(name string) (Severity, bool) {
+	for s := SeverityInfo; s <= SeverityError; s++ {
+		if s.String() == name {
+			return s, true
+		}
+	}
+	return 0, false
+}
+
+// Finding is a problem reported by Lint. Offset is the position of the
+// node or property in the source file, or -1.
+type Finding struct {
+	Severity Severity
+	Offset   int
+	Message  string
+}
+
+func (f Finding) String() string {
+	if f.Offset >= 0 {
+		return fmt.Sprintf("%s: 0x%x: %s", f.Severity, f.Offset, f.Message)
+	}
+	return fmt.Sprintf("%s: %s", f.Severity, f.Message)
+}
+
+// isCollection reports whether elements with this name are lists, like
+// CameraCollection or ChildrenList.
+func isCollection(name string) bool {
+	return strings.HasSuffix(name, "Collection") || strings.HasSuffix(name, "List")
+}
+
+// Lint checks the document for structural smells: indices out of range,
+// duplicate property names, unreferenced or duplicate table entries,
+// strings used both as names and as values, and empty collections.
+func (d *Document) Lint() []Finding {
+	var findings []Finding
+	add := func(s Severity, offset int, format string, args ...interface{}) {
+		findings = append(findings, Finding{s, offset, fmt.Sprintf(format, args...)})
+	}
+	str := func(i int) string {
+		if i >= 0 && i < len(d.Strings) {
+			return d.Strings[i]
+		}
+		return fmt.Sprintf("#%d", i)
+	}
+
+	nameRefs := make([]int, len(d.Strings))
+	valueRefs := make([]int, len(d.TypedValues))
+	var walk func(n *Node)
+	walk = func(n *Node) {
+		name := str(n.Name)
+		if n.Name < 0 || n.Name >= len(d.Strings) {
+			add(SeverityError, n.Offset, "element name index %d out of range", n.Name)
+		} else {
+			nameRefs[n.Name]++
+		}
+		seen := make(map[int]bool)
+		for _, p := range n.Properties {
+			if p.Name < 0 || p.Name >= len(d.Strings) {
+				add(SeverityError, p.Offset, "%s: property name index %d out of range", name, p.Name)
+			} else {
+				nameRefs[p.Name]++
+				if seen[p.Name] {
+					add(SeverityError, p.Offset, "%s: duplicate property %s", name, str(p.Name))
+				}
+				seen[p.Name] = true
+			}
+			if p.Value < 0 || p.Value >= len(d.TypedValues) {
+				add(SeverityError, p.Offset, "%s.%s: value index %d out of range", name, str(p.Name), p.Value)
+			} else {
+				valueRefs[p.Value]++
+			}
+		}
+		if len(n.Children) == 0 && isCollection(name) {
+			add(SeverityInfo, n.Offset, "empty collection %s", name)
+		}
+		for _, c := range n.Children {
+			walk(c)
+		}
+	}
+	if d.Element == nil {
+		add(SeverityError, -1, "document has no root element")
+	} else {
+		walk(d.Element)
+	}
+
+	// typed values
+	stringRefs := make([]int, len(d.Strings))
+	shared := false
+	byEncoding := make(map[string][]int)
+	for i, tv := range d.TypedValues {
+		if tv == nil {
+			add(SeverityError, -1, "typed value #%d: unknown type", i)
+			continue
+		}
+		s, isString := tv.(String)
+		if isString && (s.Value < 0 || s.Value >= len(d.Strings)) {
+			// checked first, formatting the value would index past the table
+			add(SeverityError, -1, "typed value #%d: string index %d out of range", i, s.Value)
+			continue
+		}
+		if valueRefs[i] == 0 {
+			typ, val := d.GetTypeAndValue(tv, &Options{})
+			add(SeverityWarning, -1, "typed value #%d (%s %s) is not referenced", i, typ, val)
+			continue
+		}
+		if valueRefs[i] > 1 {
+			shared = true
+		}
+		if isString {
+			stringRefs[s.Value]++
+		}
+		key := valueKey(tv)
+		byEncoding[key] = append(byEncoding[key], i)
+	}
+	var dups [][]int
+	for _, indices := range byEncoding {
+		if len(indices) > 1 {
+			dups = append(dups, indices)
+		}
+	}
+	sort.Slice(dups, func(i, j int) bool { return dups[i][0] < dups[j][0] })
+	if shared {
+		// the file shares typed values, so copies are likely hand edits
+		for _, indices := range dups {
+			typ, val := d.GetTypeAndValue(d.TypedValues[indices[0]], &Options{})
+			add(SeverityWarning, -1, "typed value %s %s is stored %d times (#%s)",
+				typ, val, len(indices), joinInts(indices, ", #"))
+		}
+	} else if len(dups) > 0 {
+		add(SeverityInfo, -1, "typed values are not shared: %d values are stored more than once", len(dups))
+	}
+
+	// strings
+	for i, s := range d.Strings {
+		switch {
+		case nameRefs[i] == 0 && stringRefs[i] == 0:
+			add(SeverityWarning, -1, "string #%d \"%s\" is not referenced", i, s)
+		case nameRefs[i] > 0 && stringRefs[i] > 0:
+			add(SeverityInfo, -1, "string #%d \"%s\" is used both as a name and as a value", i, s)
+		}
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		return findings[i].Severity > findings[j].Severity
+	})
+	return findings
+}
+
+func joinInts(values []int, sep string) string {
+	arr := make([]string, len(values))
+	for i, v := range values {
+		arr[i] = fmt.Sprintf("%d", v)
+	}
+	return strings.Join(arr, sep)
+}
+
+// TypeStats counts the types used for each property across a corpus, so
+// that properties whose type differs from the majority can be reported.
+// Properties are identified by element and property name.
+type TypeStats struct {
+	counts map[string]map[string]int
+}
+
+func NewTypeStats() *TypeStats {
+	return &TypeStats{counts: make(map[string]map[string]int)}
+}
+
+// walkTypes calls f for every property of the document with its key and
+// XML type name.
+func walkTypes(d *Document, f func(n *Node, p *Property, key string, typ string)) {
+	var walk func(n *Node)
+	walk = func(n *Node) {
+		for _, p := range n.Properties {
+			if n.Name < 0 || n.Name >= len(d.Strings) || p.Name < 0 || p.Name >= len(d.Strings) {
+				continue
+			}
+			if p.Value < 0 || p.Value >= len(d.TypedValues) {
+				continue
+			}
+			tv := d.TypedValues[p.Value]
+			if s, ok := tv.(String); tv == nil || ok && (s.Value < 0 || s.Value >= len(d.Strings)) {
+				continue
+			}
+			typ, _ := d.GetTypeAndValue(tv, &Options{})
+			f(n, p, d.Strings[n.Name]+"."+d.Strings[p.Name], typ)
+		}
+		for _, c := range n.Children {
+			walk(c)
+		}
+	}
+	if d.Element != nil {
+		walk(d.Element)
+	}
+}
+
+// Add counts the property types of a document.
+func (s *TypeStats) Add(d *Document) {
+	walkTypes(d, func(n *Node, p *Property, key string, typ string) {
+		m := s.counts[key]
+		if m == nil {
+			m = make(map[string]int)
+			s.counts[key] = m
+		}
+		m[typ]++
+	})
+}
+
+// majority returns the most used type for key and its share of all uses.
+func (s *TypeStats) majority(key string) (string, int, int) {
+	best, bestCount, total := "", 0, 0
+	for typ, count := range s.counts[key] {
+		total += count
+		if count > bestCount || count == bestCount && typ < best {
+			best, bestCount = typ, count
+		}
+	}
+	return best, bestCount, total
+}
+
+// Lint reports properties of the document whose type differs from the
+// one used by at least three quarters of the corpus. Properties without
+// such a clear majority are not reported.
+func (s *TypeStats) Lint(d *Document) []Finding {
+	var findings []Finding
+	walkTypes(d, func(n *Node, p *Property, key string, typ string) {
+		best, count, total := s.majority(key)
+		if typ == best || count*4 < total*3 {
+			return
+		}
+		findings = append(findings, Finding{SeverityWarning, p.Offset,
+			fmt.Sprintf("%s is %s, but %s in %d of %d uses", key, typ, best, count, total)})
+	})
+	return findings
+}
@@ -0,0 +1,47 @@
+package data
+
+import (
+	"strings"
+	"testing"
+)
+
+// hasFinding reports whether findings has one of severity s whose message
+// contains text.
+func hasFinding(findings []Finding, s Severity, text string) bool {
+	for _, f := range findings {
+		if f.Severity == s && strings.Contains(f.Message, text) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestLintBadValues(t *testing.T) {
+	tests := []struct {
+		name  string
+		value TypedValue
+		want  string
+	}{
+		{"unknown type", nil, "typed value #0: unknown type"},
+		{"string index", String{9}, "typed value #0: string index 9 out of range"},
+		{"negative string index", String{-1}, "typed value #0: string index -1 out of range"},
+	}
+	for _, test := range tests {
+		for _, referenced := range []bool{true, false} {
+			d := NewDocument()
+			d.Element = d.NewNode("Root")
+			d.TypedValues = []TypedValue{test.value, test.value}
+			if referenced {
+				d.Element.Properties = append(d.Element.Properties,
+					&Property{Name: d.GetString("a"), Value: 0},
+					&Property{Name: d.GetString("b"), Value: 1},
+					&Property{Name: d.GetString("c"), Value: 1})
+			}
+			findings := d.Lint()
+			if !hasFinding(findings, SeverityError, test.want) {
+				t.Errorf("%s (referenced %v): got %v, want error %q", test.name, referenced, findings, test.want)
+			}
+			NewTypeStats().Add(d)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package data
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const ManifestName = ".ibx1-manifest.json"
+
+// manifestFormat changes whenever the meaning of the entries does.
+// Format 2 stores output paths relative to the output directory.
+const manifestFormat = 2
+
+// Manifest records, for every file converted into an output directory,
+// the content hashes of its input and output. It is used to skip files
+// whose inputs have not changed since the previous run.
+type Manifest struct {
+	Format  int                       `json:"format"`
+	Version string                    `json:"version"`
+	Options string                    `json:"options"`
+	Files   map[string]*ManifestEntry `json:"files"`
+	path    string
+	dir     string // the output directory
+	seen    map[string]bool
+}
+
+type ManifestEntry struct {
+	Input      string            `json:"input"`
+	Output     string            `json:"output"`
+	OutputPath string            `json:"outputPath"`         // relative to the output directory
+	Includes   map[string]string `json:"includes,omitempty"` // included file -> hash
+}
+
+// LoadManifest reads the manifest kept in outdir. A missing manifest, or
+// one written by a different tool version or with different options,
+// yields an empty manifest so that everything is rebuilt.
+func LoadManifest(outdir string, version string, options string) (*Manifest, error) {
+	m := &Manifest{
+		Format:  manifestFormat,
+		Version: version,
+		Options: options,
+		Files:   make(map[string]*ManifestEntry),
+		path:    path.Join(outdir, ManifestName),
+		dir:     outdir,
+		seen:    make(map[string]bool),
+	}
+	bs, err := ioutil.ReadFile(m.path)
+	if os.IsNotExist(err) {
+		return m, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var old Manifest
+	err = json.Unmarshal(bs, &old)
+	if err != nil {
+		return nil, err
+	}
+	if old.Format == manifestFormat && old.Version == version && old.Options == options && old.Files != nil {
+		m.Files = old.Files
+	}
+	return m, nil
+}
+
+// UpToDate reports whether the file at relative path rel can be skipped:
+// its input hash matches the recorded one and the recorded output is
+// still present and unmodified, and so are the files it includes.
+func (m *Manifest) UpToDate(rel string, inPath string, outPath string) bool {
+	m.seen[rel] = true
+	entry, ok := m.Files[rel]
+	if !ok || entry.OutputPath != m.relative(outPath) {
+		return false
+	}
+	inHash, err := HashFile(inPath)
+	if err != nil || inHash != entry.Input {
+		return false
+	}
+	outHash, err := HashFile(outPath)
+	if err != nil || outHash != entry.Output {
+		return false
+	}
+	for name, hash := range entry.Includes {
+		h, err := HashFile(name)
+		if err != nil || h != hash {
+			return false
+		}
+	}
+	return true
+}
+
+// Record stores the current hashes of a freshly converted file and of
+// the files it includes.
+func (m *Manifest) Record(rel string, inPath string, outPath string, includes ...string) error {
+	m.seen[rel] = true
+	inHash, err := HashFile(inPath)
+	if err != nil {
+		return err
+	}
+	outHash, err := HashFile(outPath)
+	if err != nil {
+		return err
+	}
+	entry := &ManifestEntry{Input: inHash, Output: outHash, OutputPath: m.relative(outPath)}
+	for _, name := range includes {
+		if entry.Includes == nil {
+			entry.Includes = make(map[string]string)
+		}
+		entry.Includes[name], err = HashFile(name)
+		if err != nil {
+			return err
+		}
+	}
+	m.Files[rel] = entry
+	return nil
+}
+
+// Forget drops the entry for rel, for example after a failed conversion.
+func (m *Manifest) Forget(rel string) {
+	m.seen[rel] = true
+	delete(m.Files, rel)
+}
+
+// Prune removes the outputs of all recorded files that were not seen
+// during this run, i.e. whose inputs have been deleted. It returns the
+// paths of the removed outputs.
+func (m *Manifest) Prune() ([]string, error) {
+	var rels []string
+	for rel := range m.Files {
+		if !m.seen[rel] {
+			rels = append(rels, rel)
+		}
+	}
+	sort.Strings(rels)
+	var removed []string
+	for _, rel := range rels {
+		outPath, err := m.resolve(m.Files[rel].OutputPath)
+		if err != nil {
+			return removed, err
+		}
+		err = os.Remove(outPath)
+		if err != nil && !os.IsNotExist(err) {
+			return removed, err
+		}
+		delete(m.Files, rel)
+		removed = append(removed, outPath)
+	}
+	return removed, nil
+}
+
+// relative returns outPath relative to the output directory.
+func (m *Manifest) relative(outPath string) string {
+	rel, err := filepath.Rel(m.dir, outPath)
+	if err != nil {
+		return outPath
+	}
+	return filepath.ToSlash(rel)
+}
+
+// resolve turns a recorded output path back into a path usable from the
+// working directory. Paths leading outside the output directory are
+// refused, so that a damaged manifest can't make Prune delete other files.
+func (m *Manifest) resolve(rel string) (string, error) {
+	rel = filepath.Clean(filepath.FromSlash(rel))
+	if filepath.IsAbs(rel) || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("refusing to remove %s: outside %s", rel, m.dir)
+	}
+	return filepath.Join(m.dir, rel), nil
+}
+
+func (m *Manifest) Save() error {
+	bs, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(m.path, bs, 0664)
+}
+
+// HashFile returns the hex-encoded SHA-256 of the file contents.
+func HashFile(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
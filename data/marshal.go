@@ -0,0 +1,435 @@
+package data
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const (
+	fieldProperty = iota
+	fieldChild
+	fieldChildren
+	fieldElements
+)
+
+type fieldInfo struct {
+	index int
+	name  string
+	kind  int
+	typ   string
+}
+
+func structFields(t reflect.Type) ([]*fieldInfo, error) {
+	var fields []*fieldInfo
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" || f.Name == "IBXName" {
+			// unexported
+			continue
+		}
+		tag := f.Tag.Get("ibx")
+		if tag == "-" {
+			continue
+		}
+		info := &fieldInfo{index: i, name: f.Name}
+		parts := strings.Split(tag, ",")
+		if parts[0] != "" {
+			info.name = parts[0]
+		}
+		opt := ""
+		if len(parts) > 1 {
+			opt = parts[1]
+		}
+		ft := f.Type
+		switch {
+		case opt == "child":
+			info.kind = fieldChild
+		case opt == "children":
+			info.kind = fieldChildren
+		case opt != "":
+			if !IsTypeName(opt) {
+				return nil, fmt.Errorf("field %s: unknown option \"%s\"", f.Name, opt)
+			}
+			info.kind = fieldProperty
+			info.typ = opt
+		case ft.Kind() == reflect.Slice:
+			info.kind = fieldElements
+		case ft.Kind() == reflect.Struct || ft.Kind() == reflect.Ptr:
+			info.kind = fieldChild
+		default:
+			info.kind = fieldProperty
+			info.typ = kindType(ft.Kind())
+			if info.typ == "" {
+				return nil, fmt.Errorf("field %s: unsupported type %v", f.Name, ft)
+			}
+		}
+		fields = append(fields, info)
+	}
+	return fields, nil
+}
+
+// kindType returns the default property type for a Go kind.
+func kindType(k reflect.Kind) string {
+	switch k {
+	case reflect.Int8:
+		return "int8"
+	case reflect.Uint8:
+		return "uint8"
+	case reflect.Int16:
+		return "int16"
+	case reflect.Uint16:
+		return "uint16"
+	case reflect.Int32, reflect.Int:
+		return "int32"
+	case reflect.Uint32, reflect.Uint:
+		return "uint32"
+	case reflect.Float32, reflect.Float64:
+		return "float"
+	case reflect.Bool:
+		return "bool"
+	case reflect.String:
+		return "string"
+	}
+	return ""
+}
+
+// elementName returns the element name of a struct value: the value of
+// its IBXName field, or the tag of that field, or the type name.
+func elementName(v reflect.Value) string {
+	f, ok := v.Type().FieldByName("IBXName")
+	if ok && f.Type.Kind() == reflect.String {
+		if name := v.FieldByIndex(f.Index).String(); name != "" {
+			return name
+		}
+		if tag := f.Tag.Get("ibx"); tag != "" {
+			return tag
+		}
+	}
+	return v.Type().Name()
+}
+
+// Marshal and Unmarshal map Go structs to IBX1 trees, in the style of
+// encoding/xml. A struct corresponds to an element, and its fields are
+// mapped according to their "ibx" tags:
+//
+//	Name  string    `ibx:"cameraName,string"`        property of the given type
+//	Blend float32   `ibx:"blendLength"`              property, type from the Go type
+//	Cams  []Camera  `ibx:"CameraCollection,children"` child element holding the items
+//	Mods  []Mod     `ibx:"ZoomModifier"`             repeated child elements
+//	Tgt   *Target   `ibx:"CameraTarget,child"`       single child element
+//	Skip  int       `ibx:"-"`                        ignored
+//
+// Fields without a tag use the field name. A string field named IBXName
+// holds the element name: its tag gives the default, a non-empty value
+// overrides it on Marshal, and Unmarshal stores the actual name in it.
+// Property types are the XML type names: int8, uint8, int16, uint16,
+// int32, uint32, float, bool and string.
+//
+// Marshal builds a Document from v, which must be a struct or a pointer
+// to one. Strings and typed values are interned, and equal typed values
+// are shared.
+func Marshal(v interface{}) (*Document, error) {
+	doc := NewDocument()
+	node, err := marshalNode(doc, reflect.ValueOf(v), "")
+	if err != nil {
+		return nil, err
+	}
+	doc.Element = node
+	doc.attach(node)
+	return doc, nil
+}
+
+func marshalNode(doc *Document, v reflect.Value, name string) (*Node, error) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil, fmt.Errorf("nil element %s", name)
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("cannot marshal %v as an element", v.Type())
+	}
+	if name == "" {
+		name = elementName(v)
+	}
+	fields, err := structFields(v.Type())
+	if err != nil {
+		return nil, err
+	}
+	node := doc.NewNode(name)
+	for _, f := range fields {
+		fv := v.Field(f.index)
+		switch f.kind {
+		case fieldProperty:
+			tv, err := toTypedValue(doc, fv, f.typ)
+			if err != nil {
+				return nil, fmt.Errorf("%s.%s: %v", name, f.name, err)
+			}
+			p := &Property{Name: doc.GetString(f.name), Value: doc.AddTypedValue(tv), Offset: -1}
+			node.Properties = append(node.Properties, p)
+		case fieldChild:
+			if (fv.Kind() == reflect.Ptr || fv.Kind() == reflect.Interface) && fv.IsNil() {
+				continue
+			}
+			c, err := marshalNode(doc, fv, f.name)
+			if err != nil {
+				return nil, err
+			}
+			node.Children = append(node.Children, c)
+		case fieldChildren:
+			wrapper := doc.NewNode(f.name)
+			for i := 0; i < fv.Len(); i++ {
+				c, err := marshalNode(doc, fv.Index(i), "")
+				if err != nil {
+					return nil, err
+				}
+				wrapper.Children = append(wrapper.Children, c)
+			}
+			node.Children = append(node.Children, wrapper)
+		case fieldElements:
+			for i := 0; i < fv.Len(); i++ {
+				c, err := marshalNode(doc, fv.Index(i), f.name)
+				if err != nil {
+					return nil, err
+				}
+				node.Children = append(node.Children, c)
+			}
+		}
+	}
+	return node, nil
+}
+
+func toTypedValue(doc *Document, v reflect.Value, typ string) (TypedValue, error) {
+	switch typ {
+	case "string":
+		if v.Kind() == reflect.String {
+			return String{doc.GetString(v.String())}, nil
+		}
+	case "bool":
+		if v.Kind() == reflect.Bool {
+			return Bool{v.Bool()}, nil
+		}
+	case "float":
+		if v.Kind() == reflect.Float32 || v.Kind() == reflect.Float64 {
+			return Float{float32(v.Float())}, nil
+		}
+	case "int8", "uint8", "byte", "int16", "short", "uint16", "int32", "int", "uint32":
+		var n int64
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			n = v.Int()
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			n = int64(v.Uint())
+		default:
+			return nil, fmt.Errorf("cannot marshal %v as %s", v.Type(), typ)
+		}
+		switch typ {
+		case "int8":
+			return Int8{int8(n)}, nil
+		case "uint8", "byte":
+			return UInt8{uint8(n)}, nil
+		case "int16", "short":
+			return Int16{int16(n)}, nil
+		case "uint16":
+			return UInt16{uint16(n)}, nil
+		case "int32", "int":
+			return Int32{int32(n)}, nil
+		case "uint32":
+			return UInt32{uint32(n)}, nil
+		}
+	default:
+		// registered types are converted through their text form
+		if t := TypeByName(typ); t != nil {
+			tv, err := t.Parse(doc, fmt.Sprint(v.Interface()))
+			if err != nil {
+				return nil, fmt.Errorf("cannot marshal %v as %s: %v", v.Type(), typ, err)
+			}
+			return tv, nil
+		}
+	}
+	return nil, fmt.Errorf("cannot marshal %v as %s", v.Type(), typ)
+}
+
+// Unmarshal fills v, which must be a pointer to a struct, from the root
+// element of doc. Properties and children that have no matching field
+// are ignored.
+func Unmarshal(doc *Document, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("Unmarshal needs a non-nil pointer, got %v", reflect.TypeOf(v))
+	}
+	if doc.Element == nil {
+		return fmt.Errorf("document has no root element")
+	}
+	return unmarshalNode(doc, doc.Element, rv.Elem())
+}
+
+func unmarshalNode(doc *Document, node *Node, v reflect.Value) error {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return fmt.Errorf("cannot unmarshal an element into %v", v.Type())
+	}
+	name := doc.Strings[node.Name]
+	if f := v.FieldByName("IBXName"); f.IsValid() && f.Kind() == reflect.String && f.CanSet() {
+		f.SetString(name)
+	}
+	fields, err := structFields(v.Type())
+	if err != nil {
+		return err
+	}
+	for _, f := range fields {
+		fv := v.Field(f.index)
+		switch f.kind {
+		case fieldProperty:
+			for _, p := range node.Properties {
+				if doc.Strings[p.Name] != f.name {
+					continue
+				}
+				err := fromTypedValue(doc, doc.TypedValues[p.Value], fv)
+				if err != nil {
+					return fmt.Errorf("%s.%s: %v", name, f.name, err)
+				}
+				break
+			}
+		case fieldChild:
+			for _, c := range node.Children {
+				if doc.Strings[c.Name] != f.name {
+					continue
+				}
+				err := unmarshalNode(doc, c, fv)
+				if err != nil {
+					return err
+				}
+				break
+			}
+		case fieldChildren:
+			for _, c := range node.Children {
+				if doc.Strings[c.Name] != f.name {
+					continue
+				}
+				err := unmarshalItems(doc, c.Children, fv)
+				if err != nil {
+					return err
+				}
+				break
+			}
+		case fieldElements:
+			var items []*Node
+			for _, c := range node.Children {
+				if doc.Strings[c.Name] == f.name {
+					items = append(items, c)
+				}
+			}
+			err := unmarshalItems(doc, items, fv)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func unmarshalItems(doc *Document, nodes []*Node, v reflect.Value) error {
+	if v.Kind() != reflect.Slice {
+		return fmt.Errorf("cannot unmarshal a list of elements into %v", v.Type())
+	}
+	for _, n := range nodes {
+		item := reflect.New(v.Type().Elem()).Elem()
+		err := unmarshalNode(doc, n, item)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.Append(v, item))
+	}
+	return nil
+}
+
+func fromTypedValue(doc *Document, tv TypedValue, v reflect.Value) error {
+	switch tv := tv.(type) {
+	case String:
+		if v.Kind() == reflect.String {
+			if tv.Value < 0 || tv.Value >= len(doc.Strings) {
+				return fmt.Errorf("string index out of range: %d", tv.Value)
+			}
+			v.SetString(doc.Strings[tv.Value])
+			return nil
+		}
+	case Bool:
+		if v.Kind() == reflect.Bool {
+			v.SetBool(tv.Value)
+			return nil
+		}
+	case Float:
+		if v.Kind() == reflect.Float32 || v.Kind() == reflect.Float64 {
+			v.SetFloat(float64(tv.Value))
+			return nil
+		}
+	case Int8:
+		return setInt(v, int64(tv.Value))
+	case UInt8:
+		return setInt(v, int64(tv.Value))
+	case Int16:
+		return setInt(v, int64(tv.Value))
+	case UInt16:
+		return setInt(v, int64(tv.Value))
+	case Int32:
+		return setInt(v, int64(tv.Value))
+	case UInt32:
+		return setInt(v, int64(tv.Value))
+	default:
+		// registered types are converted through their text form
+		if t := TypeOf(tv); t != nil {
+			return setText(v, t.Format(doc, tv, &Options{}))
+		}
+	}
+	return fmt.Errorf("cannot unmarshal %v into %v", tv, v.Type())
+}
+
+func setText(v reflect.Value, text string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(text)
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(text, 0, 64)
+		if err == nil {
+			v.SetInt(n)
+			return nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(text, 0, 64)
+		if err == nil {
+			v.SetUint(n)
+			return nil
+		}
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(text, 64)
+		if err == nil {
+			v.SetFloat(f)
+			return nil
+		}
+	}
+	return fmt.Errorf("cannot unmarshal \"%s\" into %v", text, v.Type())
+}
+
+func setInt(v reflect.Value, n int64) error {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(n)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v.SetUint(uint64(n))
+		return nil
+	case reflect.Float32, reflect.Float64:
+		v.SetFloat(float64(n))
+		return nil
+	}
+	return fmt.Errorf("cannot unmarshal integer %d into %v", n, v.Type())
+}
@@ -0,0 +1,151 @@
+package data
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type testCamera struct {
+	IBXName     string  `ibx:"CameraInstance"`
+	CameraName  string  `ibx:"cameraName,string"`
+	BlendLength float32 `ibx:"blendLength"`
+	BlendCurve  int     `ibx:"blendCurve,int8"`
+	Weight      uint16
+}
+
+type testTarget struct {
+	Bone   string `ibx:"bone"`
+	Locked bool   `ibx:"locked"`
+}
+
+type testCameraNode struct {
+	IBXName string       `ibx:"FIFAPresentationNodes.CameraNode"`
+	Name    string       `ibx:"name"`
+	Cameras []testCamera `ibx:"CameraCollection,children"`
+	Mods    []testTarget `ibx:"Modifier"`
+	Target  *testTarget  `ibx:"CameraTarget,child"`
+	Skip    int          `ibx:"-"`
+	hidden  int
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	in := testCameraNode{
+		Name: "intro",
+		Cameras: []testCamera{
+			{CameraName: "wide", BlendLength: 0.5, BlendCurve: -2, Weight: 300},
+			{IBXName: "OtherCamera", CameraName: "close", BlendLength: 0.5},
+		},
+		Mods:   []testTarget{{Bone: "head"}, {Bone: "hips", Locked: true}},
+		Target: &testTarget{Bone: "ball", Locked: true},
+		Skip:   7,
+		hidden: 8,
+	}
+	doc, err := Marshal(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err = roundTrip(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out testCameraNode
+	if err := Unmarshal(doc, &out); err != nil {
+		t.Fatal(err)
+	}
+	want := in
+	want.IBXName = "FIFAPresentationNodes.CameraNode"
+	want.Cameras = []testCamera{in.Cameras[0], in.Cameras[1]}
+	want.Cameras[0].IBXName = "CameraInstance"
+	want.Skip, want.hidden = 0, 0
+	if !reflect.DeepEqual(out, want) {
+		t.Errorf("got %+v, want %+v", out, want)
+	}
+}
+
+func TestMarshalTree(t *testing.T) {
+	doc, err := Marshal(testCameraNode{
+		Cameras: []testCamera{{CameraName: "a", BlendLength: 1}, {CameraName: "b", BlendLength: 1}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	var walk func(n *Node)
+	walk = func(n *Node) {
+		names = append(names, n.ElementName())
+		if n.Offset != -1 {
+			t.Errorf("%s: offset %d, want -1", n.ElementName(), n.Offset)
+		}
+		for _, p := range n.Properties {
+			if p.Offset != -1 {
+				t.Errorf("%s.%s: offset %d, want -1", n.ElementName(), doc.Strings[p.Name], p.Offset)
+			}
+		}
+		for _, c := range n.Children {
+			walk(c)
+		}
+	}
+	walk(doc.Element)
+	want := "FIFAPresentationNodes.CameraNode CameraCollection CameraInstance CameraInstance"
+	if got := strings.Join(names, " "); got != want {
+		t.Errorf("got elements %s, want %s", got, want)
+	}
+	cams := doc.Element.Children[0].Children
+	if cams[0].Property("blendLength").Value != cams[1].Property("blendLength").Value {
+		t.Errorf("equal typed values are not shared")
+	}
+	if typ, val, _ := cams[0].Text("blendCurve"); typ != "int8" || val != "0" {
+		t.Errorf("blendCurve: got %s:%s, want int8:0", typ, val)
+	}
+	if typ, _, _ := cams[0].Text("Weight"); typ != "uint16" {
+		t.Errorf("Weight: got type %s, want uint16", typ)
+	}
+}
+
+func TestMarshalErrors(t *testing.T) {
+	type badOption struct {
+		A int `ibx:"a,nosuchtype"`
+	}
+	type badKind struct {
+		A map[string]int
+	}
+	type badType struct {
+		A string `ibx:"a,int8"`
+	}
+	type nilItem struct {
+		Items []*testTarget `ibx:"Item"`
+	}
+	tests := []struct {
+		v    interface{}
+		want string
+	}{
+		{badOption{}, "unknown option \"nosuchtype\""},
+		{badKind{}, "unsupported type"},
+		{badType{}, "cannot marshal string as int8"},
+		{nilItem{Items: []*testTarget{nil}}, "nil element Item"},
+		{42, "cannot marshal int as an element"},
+	}
+	for _, test := range tests {
+		_, err := Marshal(test.v)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("Marshal(%#v): got %v, want %q", test.v, err, test.want)
+		}
+	}
+
+	doc := NewDocument()
+	var out testCameraNode
+	if err := Unmarshal(doc, out); err == nil {
+		t.Errorf("Unmarshal into a non-pointer: no error")
+	}
+	if err := Unmarshal(doc, &out); err == nil {
+		t.Errorf("Unmarshal of a document without a root: no error")
+	}
+	doc.Element = doc.NewNode("Root").SetString("name", "x")
+	var wrong struct {
+		Name int `ibx:"name"`
+	}
+	if err := Unmarshal(doc, &wrong); err == nil {
+		t.Errorf("Unmarshal of a string into an int: no error")
+	}
+}
@@ -0,0 +1,291 @@
+package data
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// ElementName returns the name of n.
+func (n *Node) ElementName() string {
+	return n.document().Strings[n.Name]
+}
+
+// Rename changes the element name of n.
+func (n *Node) Rename(name string) {
+	n.Name = n.document().GetString(name)
+}
+
+// Find returns n and all its descendants with the given element name,
+// in document order.
+func (n *Node) Find(name string) []*Node {
+	d := n.document()
+	return n.FindFunc(func(c *Node) bool {
+		return d.Strings[c.Name] == name
+	})
+}
+
+// FindFunc returns n and all its descendants for which match returns
+// true, in document order.
+func (n *Node) FindFunc(match func(*Node) bool) []*Node {
+	var result []*Node
+	var walk func(c *Node)
+	walk = func(c *Node) {
+		if match(c) {
+			result = append(result, c)
+		}
+		for _, gc := range c.Children {
+			walk(gc)
+		}
+	}
+	walk(n)
+	return result
+}
+
+// Parent returns the parent of n in the tree of d, or nil if n is the
+// root or not in the tree.
+func (d *Document) Parent(n *Node) *Node {
+	if d.Element == nil {
+		return nil
+	}
+	found := d.Element.FindFunc(func(c *Node) bool {
+		for _, gc := range c.Children {
+			if gc == n {
+				return true
+			}
+		}
+		return false
+	})
+	if len(found) == 0 {
+		return nil
+	}
+	return found[0]
+}
+
+// Remove removes n and its subtree from the tree of d. It reports whether
+// n was found. The strings and typed values it used stay in the tables
+// until Compact is called.
+func (d *Document) Remove(n *Node) bool {
+	parent := d.Parent(n)
+	if parent == nil {
+		return false
+	}
+	return parent.RemoveChild(n)
+}
+
+// RemoveChild removes c from the children of n.
+func (n *Node) RemoveChild(c *Node) bool {
+	for i, x := range n.Children {
+		if x == c {
+			n.Children = append(n.Children[:i], n.Children[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// InsertChild inserts c at position i of the children of n. Nodes from
+// another document must be copied with Import first.
+func (n *Node) InsertChild(i int, c *Node) error {
+	if i < 0 || i > len(n.Children) {
+		return fmt.Errorf("child index out of range: %d", i)
+	}
+	if c.doc != nil && c.doc != n.doc {
+		return fmt.Errorf("node belongs to another document")
+	}
+	n.Children = append(n.Children, nil)
+	copy(n.Children[i+1:], n.Children[i:])
+	n.Children[i] = c
+	return nil
+}
+
+// MoveChild moves the child at position from to position to.
+func (n *Node) MoveChild(from int, to int) error {
+	if from < 0 || from >= len(n.Children) || to < 0 || to >= len(n.Children) {
+		return fmt.Errorf("child index out of range")
+	}
+	c := n.Children[from]
+	n.Children = append(n.Children[:from], n.Children[from+1:]...)
+	n.Children = append(n.Children, nil)
+	copy(n.Children[to+1:], n.Children[to:])
+	n.Children[to] = c
+	return nil
+}
+
+// Import returns a deep copy of n, which may belong to another document,
+// with its strings and typed values added to d.
+func (d *Document) Import(n *Node) *Node {
+	src := n.document()
+	c := d.NewNode(src.Strings[n.Name])
+	for _, p := range n.Properties {
+		tv := src.TypedValues[p.Value]
+		if s, ok := tv.(String); ok {
+			tv = String{d.GetString(src.Strings[s.Value])}
+		}
+		c.Properties = append(c.Properties, &Property{
+			Name:   d.GetString(src.Strings[p.Name]),
+			Value:  d.AddTypedValue(tv),
+			Offset: -1,
+		})
+	}
+	for _, gc := range n.Children {
+		c.Children = append(c.Children, d.Import(gc))
+	}
+	return c
+}
+
+// Value returns the typed value of the property name, or nil.
+func (n *Node) Value(name string) TypedValue {
+	p := n.Property(name)
+	if p == nil {
+		return nil
+	}
+	return n.document().TypedValues[p.Value]
+}
+
+// Text returns the type and the value of the property name as they are
+// written in XML.
+func (n *Node) Text(name string) (string, string, bool) {
+	tv := n.Value(name)
+	if tv == nil {
+		return "", "", false
+	}
+	typ, val := n.document().GetTypeAndValue(tv, &Options{})
+	return typ, val, true
+}
+
+// SetText sets the property name from its XML type and value text, as
+// xml2dat does. Setting a property to the value it already has leaves it
+// alone, so that the file doesn't change.
+func (n *Node) SetText(name string, typ string, val string) error {
+	d := n.document()
+	value, err := d.GetTypedValue(typ, val)
+	if err != nil {
+		return err
+	}
+	if p := n.Property(name); p != nil {
+		oldTyp, oldVal := d.GetTypeAndValue(d.TypedValues[p.Value], &Options{})
+		newTyp, newVal := d.GetTypeAndValue(d.TypedValues[value], &Options{})
+		if oldTyp != newTyp || oldVal != newVal {
+			p.Value = value
+		}
+		return nil
+	}
+	n.Properties = append(n.Properties, &Property{Name: d.GetString(name), Value: value, Offset: -1})
+	return nil
+}
+
+// SetType converts the property name to another type, keeping its value
+// as far as the new type allows: floats are truncated for integer types,
+// and bools become 0/1 and back.
+func (n *Node) SetType(name string, typ string) error {
+	_, val, ok := n.Text(name)
+	if !ok {
+		return fmt.Errorf("no property %s", name)
+	}
+	if typ != "string" {
+		if val == "true" {
+			val = "1"
+		} else if val == "false" {
+			val = "0"
+		}
+	}
+	switch typ {
+	case "string", "float":
+	case "bool":
+		f, err := strconv.ParseFloat(val, 64)
+		if err != nil {
+			return fmt.Errorf("cannot convert \"%s\" to bool", val)
+		}
+		val = strconv.FormatBool(f != 0)
+	default:
+		f, err := strconv.ParseFloat(val, 64)
+		if err == nil {
+			val = strconv.FormatInt(int64(f), 10)
+		}
+	}
+	return n.SetText(name, typ, val)
+}
+
+// RenameProperty renames the first property called from.
+func (n *Node) RenameProperty(from string, to string) bool {
+	p := n.Property(from)
+	if p == nil {
+		return false
+	}
+	p.Name = n.document().GetString(to)
+	return true
+}
+
+// RemoveProperty removes the first property with the given name.
+func (n *Node) RemoveProperty(name string) bool {
+	p := n.Property(name)
+	if p == nil {
+		return false
+	}
+	for i, x := range n.Properties {
+		if x == p {
+			n.Properties = append(n.Properties[:i], n.Properties[i+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+// Compact drops strings and typed values that are no longer used by the
+// tree and renumbers the rest, keeping their relative order.
+func (d *Document) Compact() {
+	usedStrings := make([]bool, len(d.Strings))
+	usedValues := make([]bool, len(d.TypedValues))
+	if d.Element != nil {
+		d.Element.FindFunc(func(n *Node) bool {
+			usedStrings[n.Name] = true
+			for _, p := range n.Properties {
+				usedStrings[p.Name] = true
+				usedValues[p.Value] = true
+			}
+			return false
+		})
+	}
+	for i, tv := range d.TypedValues {
+		if s, ok := tv.(String); ok && usedValues[i] {
+			usedStrings[s.Value] = true
+		}
+	}
+
+	stringIndex := make([]int, len(d.Strings))
+	var strs []string
+	for i, s := range d.Strings {
+		stringIndex[i] = -1
+		if usedStrings[i] {
+			stringIndex[i] = len(strs)
+			strs = append(strs, s)
+		}
+	}
+	valueIndex := make([]int, len(d.TypedValues))
+	var values []TypedValue
+	for i, tv := range d.TypedValues {
+		valueIndex[i] = -1
+		if usedValues[i] {
+			if s, ok := tv.(String); ok {
+				tv = String{stringIndex[s.Value]}
+			}
+			valueIndex[i] = len(values)
+			values = append(values, tv)
+		}
+	}
+
+	if d.Element != nil {
+		d.Element.FindFunc(func(n *Node) bool {
+			n.Name = stringIndex[n.Name]
+			for _, p := range n.Properties {
+				p.Name = stringIndex[p.Name]
+				p.Value = valueIndex[p.Value]
+			}
+			return false
+		})
+	}
+	d.Strings = strs
+	d.TypedValues = values
+	d.indexStrings()
+	d.indexTypedValues()
+}
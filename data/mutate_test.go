@@ -0,0 +1,93 @@
+package data
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// texts returns the type and value of every property in the tree, in
+// document order.
+func texts(d *Document) []string {
+	var arr []string
+	d.Element.FindFunc(func(n *Node) bool {
+		for _, p := range n.Properties {
+			name := d.Strings[p.Name]
+			typ, val, _ := n.Text(name)
+			arr = append(arr, fmt.Sprintf("%s.%s=%s:%s", n.ElementName(), name, typ, val))
+		}
+		return false
+	})
+	return arr
+}
+
+func TestCompact(t *testing.T) {
+	d := NewDocument()
+	d.Element = d.NewNode("Root")
+	a := d.NewNode("A").SetString("name", "first").SetInt32("x", 5).SetFloat("w", 0.5)
+	b := d.NewNode("B").SetString("name", "second").SetInt8("y", 2).SetFloat("w", 0.5)
+	c := d.NewNode("C").SetString("label", "third").SetInt32("z", 5)
+	d.Element.AddChild(a).AddChild(b).AddChild(c)
+
+	if !d.Remove(b) || !a.RemoveProperty("x") {
+		t.Fatal("remove failed")
+	}
+	before := texts(d)
+	var keptStrings []string
+	for _, s := range d.Strings {
+		switch s {
+		case "B", "second", "y", "x":
+		default:
+			keptStrings = append(keptStrings, s)
+		}
+	}
+
+	d.Compact()
+	if !reflect.DeepEqual(d.Strings, keptStrings) {
+		t.Errorf("strings: got %q, want %q", d.Strings, keptStrings)
+	}
+	// 5 is still used by C.z, so it keeps its place before 0.5
+	want := []TypedValue{
+		String{d.GetString("first")}, Int32{5}, Float{0.5}, String{d.GetString("third")},
+	}
+	if !reflect.DeepEqual(d.TypedValues, want) {
+		t.Errorf("typed values: got %v, want %v", d.TypedValues, want)
+	}
+	if after := texts(d); !reflect.DeepEqual(after, before) {
+		t.Errorf("properties: got %v, want %v", after, before)
+	}
+
+	// the lookup maps follow the new indices
+	if i := d.GetString("third"); d.Strings[i] != "third" || len(d.Strings) != len(keptStrings) {
+		t.Errorf("GetString after Compact added a string or returned %d", i)
+	}
+	if i := d.AddTypedValue(Int32{5}); i != 1 {
+		t.Errorf("AddTypedValue(Int32{5}) after Compact: got %d, want 1", i)
+	}
+	if i := d.AddTypedValue(Int8{2}); i != len(want) {
+		t.Errorf("AddTypedValue(Int8{2}) after Compact: got %d, want %d", i, len(want))
+	}
+
+	d2, err := roundTrip(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := texts(d2); !reflect.DeepEqual(got, before) {
+		t.Errorf("after encoding: got %v, want %v", got, before)
+	}
+}
+
+func TestCompactKeepsUsedStrings(t *testing.T) {
+	d := NewDocument()
+	d.Element = d.NewNode("Root").SetString("a", "shared").SetString("b", "shared")
+	d.GetString("unused")
+	d.AddTypedValue(String{d.GetString("unused too")})
+	d.Compact()
+	want := []string{"Root", "shared", "a", "b"}
+	if !reflect.DeepEqual(d.Strings, want) {
+		t.Errorf("got %q, want %q", d.Strings, want)
+	}
+	if len(d.TypedValues) != 1 {
+		t.Errorf("got %d typed values, want 1", len(d.TypedValues))
+	}
+}
@@ -0,0 +1,291 @@
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ProfileDirsVar names the environment variable listing extra directories
+// to search for profiles, separated like PATH.
+const ProfileDirsVar = "IBX1_PROFILES"
+
+// Profile describes the files of one game release: the element names it
+// uses, the type of every property and the values of every enum. Empty
+// sections are not checked.
+type Profile struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// element names
+	Elements []string `json:"elements,omitempty"`
+	// "Element.property" -> type: a type name such as "uint8", "int*" or
+	// "uint*" for any integer, or alternatives like "int8|int32"
+	Properties map[string]string `json:"properties,omitempty"`
+	// enumType -> enumValues
+	Enums map[string][]string `json:"enums,omitempty"`
+	// values of the byte before the node structure
+	EncodingFlags []int `json:"encodingFlags,omitempty"`
+
+	elements map[string]bool
+	enums    map[string]map[string]bool
+}
+
+// ProfileDirs returns the directories searched for profiles: those listed
+// in $IBX1_PROFILES, then "profiles" next to the executable and in the
+// working directory.
+func ProfileDirs() []string {
+	var dirs []string
+	for _, dir := range filepath.SplitList(os.Getenv(ProfileDirsVar)) {
+		if dir != "" {
+			dirs = append(dirs, dir)
+		}
+	}
+	if exe, err := os.Executable(); err == nil {
+		dirs = append(dirs, filepath.Join(filepath.Dir(exe), "profiles"))
+	}
+	return append(dirs, "profiles")
+}
+
+// LoadProfile reads a profile from a .json file, or finds the profile
+// <name>.json in the profile directories.
+func LoadProfile(name string) (*Profile, error) {
+	if strings.HasSuffix(name, ".json") || strings.ContainsAny(name, `/\`) {
+		return ReadProfile(name)
+	}
+	for _, dir := range ProfileDirs() {
+		filename := filepath.Join(dir, name+".json")
+		if _, err := os.Stat(filename); err == nil {
+			return ReadProfile(filename)
+		}
+	}
+	return nil, fmt.Errorf("profile %s not found in %s", name, strings.Join(ProfileDirs(), ", "))
+}
+
+// ReadProfile reads a profile file.
+func ReadProfile(filename string) (*Profile, error) {
+	bs, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	p := &Profile{}
+	err = json.Unmarshal(bs, p)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	for key, spec := range p.Properties {
+		for _, typ := range strings.Split(spec, "|") {
+			if !IsTypeName(typ) && typ != "int*" && typ != "uint*" {
+				return nil, fmt.Errorf("%s: property %s: unknown type %s", filename, key, typ)
+			}
+		}
+	}
+	if p.Name == "" {
+		p.Name = strings.TrimSuffix(filepath.Base(filename), ".json")
+	}
+	p.index()
+	return p, nil
+}
+
+// ListProfiles returns the names of the profiles in the profile
+// directories. A name found in several directories is listed once.
+func ListProfiles() []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, dir := range ProfileDirs() {
+		matches, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+		for _, m := range matches {
+			name := strings.TrimSuffix(filepath.Base(m), ".json")
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (p *Profile) index() {
+	p.elements = make(map[string]bool)
+	for _, e := range p.Elements {
+		p.elements[e] = true
+	}
+	p.enums = make(map[string]map[string]bool)
+	for typ, values := range p.Enums {
+		p.enums[typ] = make(map[string]bool)
+		for _, v := range values {
+			p.enums[typ][v] = true
+		}
+	}
+}
+
+// integerWidths maps the integer types to their size. DAT files don't
+// record signedness, so uint8 is read back as int8 and the two can't be
+// told apart.
+var integerWidths = map[string]int{
+	"int8": 8, "uint8": 8,
+	"int16": 16, "uint16": 16,
+	"int32": 32, "uint32": 32,
+}
+
+// matchesType reports whether a property of type typ fits spec. Integer
+// types match the types of the same width, signed or unsigned.
+func matchesType(spec string, typ string) bool {
+	width := integerWidths[typ]
+	for _, s := range strings.Split(spec, "|") {
+		if t := TypeByName(s); t != nil {
+			s = t.Names[0]
+		}
+		switch {
+		case s == typ:
+			return true
+		case (s == "int*" || s == "uint*") && width > 0:
+			return true
+		case width > 0 && integerWidths[s] == width:
+			return true
+		}
+	}
+	return false
+}
+
+// Check reports what in the document the profile doesn't know: encoding
+// flag, element and property names, property types and enum values. Each problem is
+// reported once, at its first occurrence, with the number of occurrences.
+func (p *Profile) Check(d *Document) []Finding {
+	if p.elements == nil {
+		p.index()
+	}
+	var messages []string
+	first := make(map[string]int)
+	count := make(map[string]int)
+	add := func(offset int, format string, args ...interface{}) {
+		msg := fmt.Sprintf(format, args...)
+		if count[msg] == 0 {
+			messages = append(messages, msg)
+			first[msg] = offset
+		}
+		count[msg]++
+	}
+	if len(p.EncodingFlags) > 0 && !hasInt(p.EncodingFlags, int(d.EncodingFlag)) {
+		add(-1, "unknown encoding flag 0x%02x", d.EncodingFlag)
+	}
+	if d.Element != nil {
+		d.Element.FindFunc(func(n *Node) bool {
+			if n.Name < 0 || n.Name >= len(d.Strings) {
+				return false
+			}
+			if len(p.elements) > 0 && !p.elements[n.ElementName()] {
+				add(n.Offset, "unknown element %s", n.ElementName())
+			}
+			if len(p.enums) > 0 {
+				_, typ, ok1 := n.Text("enumType")
+				_, val, ok2 := n.Text("enumValue")
+				if ok1 && ok2 {
+					if values, ok := p.enums[typ]; !ok {
+						add(n.Offset, "unknown enum type %s", typ)
+					} else if !values[val] {
+						add(n.Offset, "unknown %s value %s", typ, val)
+					}
+				}
+			}
+			return false
+		})
+	}
+	if len(p.Properties) > 0 {
+		walkTypes(d, func(n *Node, prop *Property, key string, typ string) {
+			spec, ok := p.Properties[key]
+			if !ok {
+				add(prop.Offset, "unknown property %s", key)
+			} else if !matchesType(spec, typ) {
+				add(prop.Offset, "%s is %s, expected %s", key, typ, spec)
+			}
+		})
+	}
+	var findings []Finding
+	for _, msg := range messages {
+		text := msg
+		if count[msg] > 1 {
+			text += fmt.Sprintf(" (%d times)", count[msg])
+		}
+		findings = append(findings, Finding{SeverityWarning, first[msg], text})
+	}
+	return findings
+}
+
+func hasInt(values []int, v int) bool {
+	for _, x := range values {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+// NewProfile builds a profile from documents known to be good: every
+// encoding flag, element, property type and enum value they use.
+func NewProfile(name string, docs []*Document) *Profile {
+	p := &Profile{Name: name, Properties: make(map[string]string), Enums: make(map[string][]string)}
+	elements := make(map[string]bool)
+	types := make(map[string]map[string]bool)
+	enums := make(map[string]map[string]bool)
+	for _, d := range docs {
+		if !hasInt(p.EncodingFlags, int(d.EncodingFlag)) {
+			p.EncodingFlags = append(p.EncodingFlags, int(d.EncodingFlag))
+		}
+		if d.Element == nil {
+			continue
+		}
+		d.Element.FindFunc(func(n *Node) bool {
+			elements[n.ElementName()] = true
+			_, typ, ok1 := n.Text("enumType")
+			_, val, ok2 := n.Text("enumValue")
+			if ok1 && ok2 {
+				if enums[typ] == nil {
+					enums[typ] = make(map[string]bool)
+				}
+				enums[typ][val] = true
+			}
+			return false
+		})
+		walkTypes(d, func(n *Node, prop *Property, key string, typ string) {
+			if types[key] == nil {
+				types[key] = make(map[string]bool)
+			}
+			types[key][typ] = true
+		})
+	}
+	sort.Ints(p.EncodingFlags)
+	p.Elements = sortedKeys(elements)
+	for key, m := range types {
+		p.Properties[key] = strings.Join(sortedKeys(m), "|")
+	}
+	for typ, m := range enums {
+		p.Enums[typ] = sortedKeys(m)
+	}
+	p.index()
+	return p
+}
+
+func sortedKeys(m map[string]bool) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// WriteJSON writes the profile in the format ReadProfile reads.
+func (p *Profile) WriteJSON(w io.Writer) error {
+	bs, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(bs, '\n'))
+	return err
+}
@@ -0,0 +1,155 @@
+package data
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+)
+
+// ValueType describes one type of typed value: the type ids that start
+// its encoding in the typed-value table, how to read it, and how it is
+// written in XML. Values of the type implement TypedValue, whose Encode
+// method writes them.
+//
+// The built-in types are registered by this package. Tools can register
+// more, such as types used by newer games, with RegisterType.
+type ValueType struct {
+	// Names are the XML type names. The first is the one written to XML,
+	// the others are accepted as aliases.
+	Names []string
+	// Ids are the type id bytes ReadTypedValue reads as this type. Types
+	// that share the encoding of another type, such as uint8, have none.
+	Ids []byte
+	// Value is a value of the type, used to recognize its values.
+	Value TypedValue
+	// Read reads a value whose type id byte, already consumed, is id.
+	Read func(id byte, r ByteReader) (TypedValue, error)
+	// Format returns the value as XML text.
+	Format func(d *Document, tv TypedValue, options *Options) string
+	// Parse converts XML text into a value.
+	Parse func(d *Document, text string) (TypedValue, error)
+}
+
+var (
+	typesByName  = make(map[string]*ValueType)
+	typesByValue = make(map[reflect.Type]*ValueType)
+	typesById    [256]*ValueType
+)
+
+// RegisterType adds a value type. Its names, type ids and Go type must
+// not be registered already.
+func RegisterType(t *ValueType) error {
+	if len(t.Names) == 0 || t.Value == nil || t.Format == nil || t.Parse == nil {
+		return fmt.Errorf("value type needs names, a value, Format and Parse")
+	}
+	if len(t.Ids) > 0 && t.Read == nil {
+		return fmt.Errorf("value type %s has type ids but no Read", t.Names[0])
+	}
+	for _, name := range t.Names {
+		if typesByName[name] != nil {
+			return fmt.Errorf("value type %s is already registered", name)
+		}
+	}
+	for _, id := range t.Ids {
+		if other := typesById[id]; other != nil {
+			return fmt.Errorf("type id 0x%02x of %s is already used by %s", id, t.Names[0], other.Names[0])
+		}
+	}
+	vt := reflect.TypeOf(t.Value)
+	if other := typesByValue[vt]; other != nil {
+		return fmt.Errorf("%v values of %s are already used by %s", vt, t.Names[0], other.Names[0])
+	}
+	for _, name := range t.Names {
+		typesByName[name] = t
+	}
+	for _, id := range t.Ids {
+		typesById[id] = t
+	}
+	typesByValue[vt] = t
+	return nil
+}
+
+// mustRegisterType registers a built-in type.
+func mustRegisterType(t *ValueType) {
+	err := RegisterType(t)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// TypeByName returns the value type with the given XML type name, or nil.
+func TypeByName(name string) *ValueType {
+	return typesByName[name]
+}
+
+// TypeOf returns the value type of tv, or nil if it is not registered.
+func TypeOf(tv TypedValue) *ValueType {
+	if tv == nil {
+		return nil
+	}
+	return typesByValue[reflect.TypeOf(tv)]
+}
+
+// IsTypeName reports whether typ is the XML name of a value type.
+func IsTypeName(typ string) bool {
+	return typesByName[typ] != nil
+}
+
+// TypeNames returns all XML type names, aliases included, sorted.
+func TypeNames() []string {
+	var names []string
+	for name := range typesByName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ReadTypedValue reads one entry of the typed-value table. Type ids no
+// registered type reads are an error: the size of their value is unknown,
+// so the rest of the file can't be read.
+func ReadTypedValue(reader ByteReader) (TypedValue, error) {
+	b, err := reader.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	t := typesById[b]
+	if t == nil {
+		return nil, fmt.Errorf("unknown type id 0x%02x", b)
+	}
+	return t.Read(b, reader)
+}
+
+// readBytes reads the n bytes following a type id.
+func readBytes(reader ByteReader, n int) ([]byte, error) {
+	bs := make([]byte, n)
+	_, err := io.ReadFull(reader, bs)
+	if err != nil {
+		return nil, err
+	}
+	return bs, nil
+}
+
+// GetTypeAndValue returns the XML type name and text of a value.
+func (d *Document) GetTypeAndValue(val TypedValue, options *Options) (string, string) {
+	t := TypeOf(val)
+	if t == nil {
+		return "_?_", "_?_"
+	}
+	return t.Names[0], t.Format(d, val, options)
+}
+
+// GetTypedValue parses XML text as a value of the named type and adds it
+// to the typed-value table, returning its index.
+func (d *Document) GetTypedValue(typ string, val string) (int, error) {
+	t := TypeByName(typ)
+	if t == nil {
+		return -1, fmt.Errorf("unknown type \"%s\"", typ)
+	}
+	tv, err := t.Parse(d, val)
+	if err != nil {
+		return -1, err
+	}
+	return d.AddTypedValue(tv), nil
+}
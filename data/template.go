@@ -0,0 +1,70 @@
+package data
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ExpandVariables replaces each ${name} in s with the value of the
+// variable. $${ stands for a literal "${". Undefined variables are errors.
+// With no variables at all, references are kept as they are, so that XML
+// written before templates existed reads the same.
+func ExpandVariables(s string, vars map[string]string) (string, error) {
+	if !strings.Contains(s, "${") {
+		return s, nil
+	}
+	var b strings.Builder
+	for {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			b.WriteString(s)
+			return b.String(), nil
+		}
+		if i > 0 && s[i-1] == '$' {
+			b.WriteString(s[:i-1])
+			b.WriteString("${")
+			s = s[i+2:]
+			continue
+		}
+		b.WriteString(s[:i])
+		if vars == nil {
+			b.WriteString("${")
+			s = s[i+2:]
+			continue
+		}
+		end := strings.Index(s[i:], "}")
+		if end < 0 {
+			return "", fmt.Errorf("unterminated variable reference in \"%s\"", s)
+		}
+		name := s[i+2 : i+end]
+		if !IsVariableName(name) {
+			return "", fmt.Errorf("bad variable name \"%s\"", name)
+		}
+		value, ok := vars[name]
+		if !ok {
+			return "", fmt.Errorf("undefined variable ${%s}", name)
+		}
+		b.WriteString(value)
+		s = s[i+end+1:]
+	}
+}
+
+// EscapeVariables reverses ExpandVariables for text without variables, so
+// that strings containing "${" survive a round trip through XML.
+func EscapeVariables(s string) string {
+	return strings.Replace(s, "${", "$${", -1)
+}
+
+// IsVariableName reports whether name can be used in ${name}: ASCII
+// letters, digits, '_', '-' and '.'.
+func IsVariableName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
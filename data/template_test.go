@@ -0,0 +1,88 @@
+package data
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExpandVariables(t *testing.T) {
+	vars := map[string]string{"stadium": "Anfield", "cam.zoom": "0.5", "empty": ""}
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"plain", "plain"},
+		{"${stadium}", "Anfield"},
+		{"${stadium}_${cam.zoom}", "Anfield_0.5"},
+		{"a${empty}b", "ab"},
+		{"cost: $5 ${stadium}$", "cost: $5 Anfield$"},
+		{"$${stadium}", "${stadium}"},
+		{"$$${stadium}", "$${stadium}"},
+		{"$${", "${"},
+		{"${stadium}$${x}${stadium}", "Anfield${x}Anfield"},
+	}
+	for _, test := range tests {
+		got, err := ExpandVariables(test.text, vars)
+		if err != nil {
+			t.Errorf("%q: %v", test.text, err)
+		} else if got != test.want {
+			t.Errorf("%q: got %q, want %q", test.text, got, test.want)
+		}
+	}
+}
+
+func TestExpandVariablesErrors(t *testing.T) {
+	vars := map[string]string{"a": "1"}
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"${a", "unterminated variable reference"},
+		{"${a}${b", "unterminated variable reference"},
+		{"${b}", "undefined variable ${b}"},
+		{"${}", "bad variable name \"\""},
+		{"${a b}", "bad variable name \"a b\""},
+		{"${a${b}}", "bad variable name \"a${b\""},
+	}
+	for _, test := range tests {
+		got, err := ExpandVariables(test.text, vars)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%q: got %q, %v, want error %q", test.text, got, err, test.want)
+		}
+	}
+	// an empty set of variables still defines none
+	if _, err := ExpandVariables("${a}", map[string]string{}); err == nil {
+		t.Errorf("${a} with no variables defined: no error")
+	}
+}
+
+func TestExpandVariablesWithoutVariables(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"${a}", "${a}"},
+		{"${a", "${a"},
+		{"${not a name}", "${not a name}"},
+		{"$${a}", "${a}"},
+	}
+	for _, test := range tests {
+		got, err := ExpandVariables(test.text, nil)
+		if err != nil {
+			t.Errorf("%q: %v", test.text, err)
+		} else if got != test.want {
+			t.Errorf("%q: got %q, want %q", test.text, got, test.want)
+		}
+	}
+}
+
+func TestEscapeVariables(t *testing.T) {
+	for _, s := range []string{"", "plain", "${a}", "$${a}", "$", "a$", "${", "x${y}z${"} {
+		for _, vars := range []map[string]string{nil, {"a": "1", "y": "2"}} {
+			got, err := ExpandVariables(EscapeVariables(s), vars)
+			if err != nil || got != s {
+				t.Errorf("%q (variables %v): got %q, %v", s, vars, got, err)
+			}
+		}
+	}
+}
@@ -2,8 +2,6 @@ package data
 
 import (
 	"fmt"
-	"os"
-	"strconv"
 	"strings"
 )
 
@@ -14,6 +12,8 @@ type Document struct {
 	tvMap            map[string]int
 	Element          *Node
 	ShareTypedValues bool
+	EncodingFlag     byte     // the byte before the node structure, 0 for the default
+	Includes         []string // files included by ReadXML
 }
 
 type Number struct {
@@ -22,18 +22,23 @@ type Number struct {
 
 type TypedValue interface {
 	TypeId() int
-	Encode() []byte
+	// Encode writes the type id and the value, as stored in the
+	// typed-value table.
+	Encode() ([]byte, error)
 }
 
 type Node struct {
 	Name       int
 	Properties []*Property
 	Children   []*Node
+	Offset     int // position in the source file, if read from one
+	doc        *Document
 }
 
 type Property struct {
-	Name  int
-	Value int
+	Name   int
+	Value  int
+	Offset int // position in the source file, if read from one
 }
 
 func (p Property) String() string {
@@ -55,80 +60,92 @@ func (n Node) String() string {
 }
 
 func (d *Document) GetString(val string) int {
+	if d.sMap == nil {
+		d.indexStrings()
+	}
 	index, ok := d.sMap[val]
 	if ok {
 		return index
 	}
 	index = len(d.Strings)
 	d.Strings = append(d.Strings, val)
-	if d.sMap == nil {
-		d.sMap = make(map[string]int, 0)
-	}
 	d.sMap[val] = index
 	return index
 }
 
-func (d *Document) GetTypedValue(typ string, val string) int {
-	key := fmt.Sprintf("%s:%s", typ, val)
+// indexStrings builds the lookup map for strings already in the table,
+// e.g. after reading a file.
+func (d *Document) indexStrings() {
+	d.sMap = make(map[string]int, len(d.Strings))
+	for i, s := range d.Strings {
+		if _, ok := d.sMap[s]; !ok {
+			d.sMap[s] = i
+		}
+	}
+}
+
+// valueKey identifies typed values with the same encoding. Values that
+// can't be encoded, such as strings whose index is too large, get a key of
+// their own, so that documents can hold them until Encode reports the
+// problem.
+func valueKey(tv TypedValue) string {
+	bs, err := tv.Encode()
+	if err != nil {
+		return fmt.Sprintf("%T %v", tv, tv)
+	}
+	return string(bs)
+}
+
+// indexTypedValues builds the lookup map for typed values already in
+// the table.
+func (d *Document) indexTypedValues() {
+	d.tvMap = make(map[string]int, len(d.TypedValues))
+	for i, tv := range d.TypedValues {
+		if tv == nil {
+			continue
+		}
+		key := valueKey(tv)
+		if _, ok := d.tvMap[key]; !ok {
+			d.tvMap[key] = i
+		}
+	}
+}
+
+// AddTypedValue adds tv to the typed-value table and returns its index.
+// With ShareTypedValues, a value with the same encoding that is already
+// in the table is re-used instead.
+func (d *Document) AddTypedValue(tv TypedValue) int {
+	if d.tvMap == nil {
+		d.indexTypedValues()
+	}
+	key := valueKey(tv)
 	index, ok := d.tvMap[key]
 	if ok && d.ShareTypedValues {
 		return index
 	}
 	index = len(d.TypedValues)
-	var tv TypedValue
-	if typ == "int8" {
-		v, err := strconv.ParseInt(val, 0, 64)
-		if err == nil {
-			tv = Int8{int8(v)}
-		}
-	} else if typ == "uint8" || typ == "byte" {
-		v, err := strconv.ParseInt(val, 0, 64)
-		if err == nil {
-			tv = UInt8{uint8(v)}
-		}
-	} else if typ == "int16" || typ == "short" {
-		v, err := strconv.ParseInt(val, 0, 64)
-		if err == nil {
-			tv = Int16{int16(v)}
-		}
-	} else if typ == "uint16" {
-		v, err := strconv.ParseInt(val, 0, 64)
-		if err == nil {
-			tv = UInt16{uint16(v)}
-		}
-	} else if typ == "int32" || typ == "int" {
-		v, err := strconv.ParseInt(val, 0, 64)
-		if err == nil {
-			tv = Int32{int32(v)}
-		}
-	} else if typ == "uint32" {
-		v, err := strconv.ParseInt(val, 0, 64)
-		if err == nil {
-			tv = UInt32{uint32(v)}
-		}
-	} else if typ == "string" {
-		index := d.GetString(val)
-		tv = String{index}
-	} else if typ == "bool" {
-		if strings.ToLower(val) == "true" {
-			tv = Bool{true}
-		} else {
-			tv = Bool{false}
+	d.TypedValues = append(d.TypedValues, tv)
+	d.tvMap[key] = index
+	return index
+}
+
+// ValueRefs returns, for every typed value, the number of properties in
+// the tree that refer to it.
+func (d *Document) ValueRefs() []int {
+	refs := make([]int, len(d.TypedValues))
+	var walk func(n *Node)
+	walk = func(n *Node) {
+		for _, p := range n.Properties {
+			if p.Value >= 0 && p.Value < len(refs) {
+				refs[p.Value]++
+			}
 		}
-	} else if typ == "float" {
-		v, err := strconv.ParseFloat(val, 32)
-		if err == nil {
-			tv = Float{float32(v)}
+		for _, c := range n.Children {
+			walk(c)
 		}
 	}
-	if tv == nil {
-		fmt.Printf("FATAL ERROR: bad value: \"%s\" or unknown type \"%s\". Aborting work\n", val, typ)
-		os.Exit(1)
-	}
-	d.TypedValues = append(d.TypedValues, tv)
-	if d.tvMap == nil {
-		d.tvMap = make(map[string]int, 0)
+	if d.Element != nil {
+		walk(d.Element)
 	}
-	d.tvMap[key] = index
-	return index
+	return refs
 }
@@ -1,7 +1,12 @@
 package data
 
 import (
+	"bytes"
+	"encoding/binary"
 	"fmt"
+	"math"
+	"strconv"
+	"strings"
 )
 
 type Int8 struct {
@@ -91,6 +96,93 @@ func (v Float) TypeId() int {
 	return 0xb0
 }
 
+func (v Int8) Encode() ([]byte, error) {
+	if v.Value >= 0 && v.Value < 0x10 {
+		arr := make([]byte, 1)
+		arr[0] = byte(v.Value)
+		return arr, nil
+	}
+	arr := make([]byte, 2)
+	arr[0] = 0x10
+	arr[1] = byte(v.Value)
+	return arr, nil
+}
+
+func (v Int16) Encode() ([]byte, error) {
+	arr := make([]byte, 3)
+	arr[0] = 0x20
+	binary.LittleEndian.PutUint16(arr[1:], uint16(v.Value))
+	return arr, nil
+}
+
+func (v Int32) Encode() ([]byte, error) {
+	arr := make([]byte, 5)
+	arr[0] = 0x30
+	binary.LittleEndian.PutUint32(arr[1:], uint32(v.Value))
+	return arr, nil
+}
+
+func (v UInt8) Encode() ([]byte, error) {
+	if v.Value >= 0 && v.Value < 0x10 {
+		arr := make([]byte, 1)
+		arr[0] = byte(v.Value)
+		return arr, nil
+	}
+	arr := make([]byte, 2)
+	arr[0] = 0x10
+	arr[1] = byte(v.Value)
+	return arr, nil
+}
+
+func (v UInt16) Encode() ([]byte, error) {
+	arr := make([]byte, 3)
+	arr[0] = 0x20
+	binary.LittleEndian.PutUint16(arr[1:], v.Value)
+	return arr, nil
+}
+
+func (v UInt32) Encode() ([]byte, error) {
+	arr := make([]byte, 5)
+	arr[0] = 0x30
+	binary.LittleEndian.PutUint32(arr[1:], v.Value)
+	return arr, nil
+}
+
+func (v Bool) Encode() ([]byte, error) {
+	arr := make([]byte, 1)
+	if v.Value == false {
+		arr[0] = 0x40
+	} else {
+		arr[0] = 0x41
+	}
+	return arr, nil
+}
+
+func (v Float) Encode() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Write([]byte{0xb0})
+	binary.Write(&buf, binary.LittleEndian, v.Value)
+	return buf.Bytes(), nil
+}
+
+// Encode writes a string reference. An index outside 0 to MaxIndex is an
+// error.
+func (v String) Encode() ([]byte, error) {
+	if v.Value < 0 || v.Value > MaxIndex {
+		return nil, indexError("string index", v.Value)
+	}
+	if v.Value < 0x10 {
+		return []byte{byte(0xc0 + v.Value)}, nil
+	}
+	if v.Value < 0x100 {
+		return []byte{0xd0, byte(v.Value)}, nil
+	}
+	arr := make([]byte, 3)
+	arr[0] = 0xe0
+	binary.BigEndian.PutUint16(arr[1:], uint16(v.Value))
+	return arr, nil
+}
+
 func (v Int8) String() string {
 	return fmt.Sprintf("{0x%02x %s %d}", v.TypeId(), "int8", v.Value)
 }
@@ -130,3 +222,159 @@ func (v Float) String() string {
 func (v String) Deref(strings []string) string {
 	return fmt.Sprintf("{0x%02x %s '%s'}", v.TypeId(), "string", strings[v.Value])
 }
+
+// idRange returns the type ids from first to last.
+func idRange(first byte, last byte) []byte {
+	var ids []byte
+	for id := int(first); id <= int(last); id++ {
+		ids = append(ids, byte(id))
+	}
+	return ids
+}
+
+// integerType describes an integer type of the given bit size. toInt and
+// fromInt convert its values; hex reports whether options ask for
+// hexadecimal output.
+func integerType(names []string, value TypedValue, ids []byte, read func(byte, ByteReader) (TypedValue, error), bits uint, hex func(*Options) bool, toInt func(TypedValue) int64, fromInt func(int64) TypedValue) *ValueType {
+	return &ValueType{
+		Names: names,
+		Ids:   ids,
+		Value: value,
+		Read:  read,
+		Format: func(d *Document, tv TypedValue, options *Options) string {
+			n := toInt(tv)
+			if hex(options) {
+				return fmt.Sprintf("0x%X", uint64(n)&(1<<bits-1))
+			}
+			return fmt.Sprintf("%d", n)
+		},
+		Parse: func(d *Document, text string) (TypedValue, error) {
+			v, err := strconv.ParseInt(text, 0, 64)
+			if err != nil {
+				return nil, fmt.Errorf("bad value: \"%s\" for type \"%s\"", text, names[0])
+			}
+			return fromInt(v), nil
+		},
+	}
+}
+
+func hex8(options *Options) bool  { return options.Hex8 }
+func hex16(options *Options) bool { return options.Hex16 }
+func hex32(options *Options) bool { return options.Hex32 }
+
+func init() {
+	mustRegisterType(integerType([]string{"int8"}, Int8{}, idRange(0x00, 0x10),
+		func(id byte, r ByteReader) (TypedValue, error) {
+			if id < 0x10 {
+				return Int8{int8(id)}, nil
+			}
+			v, err := r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			return Int8{int8(v)}, nil
+		}, 8, hex8,
+		func(tv TypedValue) int64 { return int64(tv.(Int8).Value) },
+		func(v int64) TypedValue { return Int8{int8(v)} }))
+	mustRegisterType(integerType([]string{"uint8", "byte"}, UInt8{}, nil, nil, 8, hex8,
+		func(tv TypedValue) int64 { return int64(tv.(UInt8).Value) },
+		func(v int64) TypedValue { return UInt8{uint8(v)} }))
+	mustRegisterType(integerType([]string{"int16", "short"}, Int16{}, []byte{0x20},
+		func(id byte, r ByteReader) (TypedValue, error) {
+			bs, err := readBytes(r, 2)
+			if err != nil {
+				return nil, err
+			}
+			return Int16{int16(binary.LittleEndian.Uint16(bs))}, nil
+		}, 16, hex16,
+		func(tv TypedValue) int64 { return int64(tv.(Int16).Value) },
+		func(v int64) TypedValue { return Int16{int16(v)} }))
+	mustRegisterType(integerType([]string{"uint16"}, UInt16{}, nil, nil, 16, hex16,
+		func(tv TypedValue) int64 { return int64(tv.(UInt16).Value) },
+		func(v int64) TypedValue { return UInt16{uint16(v)} }))
+	mustRegisterType(integerType([]string{"int32", "int"}, Int32{}, []byte{0x30},
+		func(id byte, r ByteReader) (TypedValue, error) {
+			bs, err := readBytes(r, 4)
+			if err != nil {
+				return nil, err
+			}
+			return Int32{int32(binary.LittleEndian.Uint32(bs))}, nil
+		}, 32, hex32,
+		func(tv TypedValue) int64 { return int64(tv.(Int32).Value) },
+		func(v int64) TypedValue { return Int32{int32(v)} }))
+	mustRegisterType(integerType([]string{"uint32"}, UInt32{}, nil, nil, 32, hex32,
+		func(tv TypedValue) int64 { return int64(tv.(UInt32).Value) },
+		func(v int64) TypedValue { return UInt32{uint32(v)} }))
+
+	mustRegisterType(&ValueType{
+		Names: []string{"string"},
+		Ids:   append(idRange(0xc0, 0xd0), 0xe0),
+		Value: String{},
+		Read: func(id byte, r ByteReader) (TypedValue, error) {
+			switch {
+			case id < 0xd0:
+				return String{int(id) - 0xc0}, nil
+			case id == 0xd0:
+				v, err := r.ReadByte()
+				if err != nil {
+					return nil, err
+				}
+				return String{int(v)}, nil
+			}
+			bs, err := readBytes(r, 2)
+			if err != nil {
+				return nil, err
+			}
+			return String{int(binary.BigEndian.Uint16(bs))}, nil
+		},
+		Format: func(d *Document, tv TypedValue, options *Options) string {
+			return EncodeString(d.Strings[tv.(String).Value])
+		},
+		Parse: func(d *Document, text string) (TypedValue, error) {
+			v, err := DecodeString(text)
+			if err != nil {
+				return nil, err
+			}
+			return String{d.GetString(v)}, nil
+		},
+	})
+	mustRegisterType(&ValueType{
+		Names: []string{"bool"},
+		Ids:   []byte{0x40, 0x41},
+		Value: Bool{},
+		Read: func(id byte, r ByteReader) (TypedValue, error) {
+			return Bool{id == 0x41}, nil
+		},
+		Format: func(d *Document, tv TypedValue, options *Options) string {
+			if tv.(Bool).Value {
+				return "true"
+			}
+			return "false"
+		},
+		Parse: func(d *Document, text string) (TypedValue, error) {
+			return Bool{strings.ToLower(text) == "true"}, nil
+		},
+	})
+	mustRegisterType(&ValueType{
+		Names: []string{"float"},
+		Ids:   []byte{0xb0},
+		Value: Float{},
+		Read: func(id byte, r ByteReader) (TypedValue, error) {
+			bs, err := readBytes(r, 4)
+			if err != nil {
+				return nil, err
+			}
+			return Float{math.Float32frombits(binary.LittleEndian.Uint32(bs))}, nil
+		},
+		Format: func(d *Document, tv TypedValue, options *Options) string {
+			return fmt.Sprintf("%f", tv.(Float).Value)
+		},
+		Parse: func(d *Document, text string) (TypedValue, error) {
+			v, err := strconv.ParseFloat(text, 32)
+			if err != nil {
+				return nil, fmt.Errorf("bad value: \"%s\" for type \"float\"", text)
+			}
+			return Float{float32(v)}, nil
+		},
+	})
+}
@@ -0,0 +1,362 @@
+package data
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrPlainXML is returned by ReadXML for XML that is not in the IBX1
+// dialect, such as the plain XML DATs shipped with the game.
+var ErrPlainXML = errors.New("not in the IBX1 XML dialect")
+
+// nodeTag is used for elements whose names can't be written as XML tags:
+// <node name="3D Camera">. Elements actually named "node" or "property"
+// are written this way too, so the tag is never ambiguous.
+const nodeTag = "node"
+
+// headerTarget is the target of the processing instruction that carries
+// document settings: <?ibx1 encoding-flag="0x01"?>.
+const headerTarget = "ibx1"
+
+type propList struct {
+	props []xmlProp
+}
+
+type xmlProp struct {
+	name     string
+	typ      string
+	value    string
+	location string // for error messages
+}
+
+// ReadXML parses the IBX1 XML dialect into a Document. Included files are
+// resolved relative to the working directory.
+func ReadXML(r io.Reader, options *Options) (*Document, error) {
+	return ReadXMLNamed(r, "", options)
+}
+
+// ReadXMLNamed is like ReadXML for XML read from the named file: included
+// files are resolved relative to it, and errors carry its name.
+func ReadXMLNamed(r io.Reader, name string, options *Options) (*Document, error) {
+	bs, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	doc := &Document{ShareTypedValues: !options.NoShare, EncodingFlag: DefaultEncodingFlag}
+
+	dec := newXMLSource(bs, name)
+
+	var stack []*Node
+	var propStack []*propList
+
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		switch tok := tok.(type) {
+		case xml.ProcInst:
+			if tok.Target != headerTarget {
+				break
+			}
+			if len(stack) > 0 || doc.Element != nil {
+				return nil, dec.errorf("<?%s?> must come before the root element", headerTarget)
+			}
+			err = readHeader(doc, string(tok.Inst), options)
+			if err != nil {
+				return nil, dec.errorf("%v", err)
+			}
+		case xml.StartElement:
+			if tok.Name.Local == "property" {
+				if len(propStack) == 0 {
+					return nil, dec.errorf("property outside of an element")
+				}
+				x := xmlProp{location: dec.location()}
+				for _, a := range tok.Attr {
+					if a.Name.Local == "name" {
+						x.name, err = DecodeString(a.Value)
+						if err != nil {
+							return nil, err
+						}
+					} else if a.Name.Local == "type" {
+						x.typ = a.Value
+					} else if a.Name.Local == "value" {
+						x.value = a.Value
+					}
+				}
+				li := propStack[len(propStack)-1]
+				li.props = append(li.props, x)
+			} else {
+				// element
+				name := tok.Name.Local
+				var attrs []xml.Attr
+				for _, a := range tok.Attr {
+					// namespace declarations, such as xmlns:ibx for
+					// includes, are not properties
+					if a.Name.Space == "xmlns" || a.Name.Space == "" && a.Name.Local == "xmlns" {
+						continue
+					}
+					attrs = append(attrs, a)
+				}
+				if name == nodeTag {
+					// fallback form: <node name="...">
+					if len(attrs) == 0 || attrs[0].Name.Space != "" || attrs[0].Name.Local != "name" {
+						return nil, dec.notIBX1()
+					}
+					name, err = DecodeString(attrs[0].Value)
+					if err != nil {
+						return nil, err
+					}
+					attrs = attrs[1:]
+				}
+				li := &propList{}
+				var mistyped *xml.Attr
+				plain := false
+				for i, a := range attrs {
+					// compact form: name="type:value"
+					x, ok := compactProp(a)
+					if !ok {
+						if !looksTyped(a) {
+							plain = true
+						} else if mistyped == nil {
+							mistyped = &attrs[i]
+						}
+						continue
+					}
+					x.location = dec.location()
+					li.props = append(li.props, x)
+				}
+				if mistyped != nil && !plain {
+					// in the dialect but for a misspelt type
+					typ := mistyped.Value[:strings.Index(mistyped.Value, ":")]
+					return nil, dec.errorf("property %s: unknown type \"%s\"", mistyped.Name.Local, typ)
+				}
+				if mistyped != nil || plain {
+					if options.Debug {
+						fmt.Printf("element %s has attributes ", tok.Name.Local)
+					}
+					return nil, dec.notIBX1()
+				}
+				elem := &Node{Name: doc.GetString(name), Offset: -1}
+				elem.Properties = []*Property{}
+				elem.Children = []*Node{}
+				stack = append(stack, elem)       //push
+				propStack = append(propStack, li) //push
+			}
+		case xml.CharData:
+			// the dialect keeps everything in attributes: text means
+			// plain XML, which would otherwise lose it
+			if len(strings.TrimSpace(string(tok))) > 0 {
+				return nil, dec.notIBX1()
+			}
+		case xml.EndElement:
+			if tok.Name.Local != "property" {
+				elem := stack[len(stack)-1]
+				stack = stack[:len(stack)-1] //pop
+				// add props
+				li := propStack[len(propStack)-1]
+				propStack = propStack[:len(propStack)-1] //pop
+				for _, x := range li.props {
+					text, err := ExpandVariables(x.value, options.Variables)
+					if err != nil {
+						return nil, fmt.Errorf("%s: property %s: %v", x.location, x.name, err)
+					}
+					// the name is interned before the value, which
+					// decides the order of the string table
+					name := doc.GetString(x.name)
+					value, err := doc.GetTypedValue(x.typ, text)
+					if err != nil {
+						return nil, fmt.Errorf("%s: property %s: %v", x.location, x.name, err)
+					}
+					p := &Property{
+						Name:   name,
+						Value:  value,
+						Offset: -1,
+					}
+					elem.Properties = append(elem.Properties, p)
+				}
+				// add element to parent element, if parent exists
+				if len(stack) > 0 {
+					parent := stack[len(stack)-1]
+					parent.Children = append(parent.Children, elem)
+				} else {
+					// done: assign
+					doc.Element = elem
+				}
+			}
+		}
+	}
+
+	if doc.Element == nil {
+		return nil, ErrPlainXML
+	}
+	doc.attach(doc.Element)
+	doc.Includes = dec.includes
+	return doc, nil
+}
+
+// readHeader applies the settings of an <?ibx1 ...?> instruction.
+func readHeader(doc *Document, inst string, options *Options) error {
+	for _, field := range strings.Fields(inst) {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 || len(kv[1]) < 2 || kv[1][0] != '"' || kv[1][len(kv[1])-1] != '"' {
+			return fmt.Errorf("bad setting in <?%s?>: %s", headerTarget, field)
+		}
+		value := kv[1][1 : len(kv[1])-1]
+		switch kv[0] {
+		case "encoding-flag":
+			flag, err := strconv.ParseUint(value, 0, 8)
+			if err != nil {
+				return fmt.Errorf("bad encoding flag \"%s\"", value)
+			}
+			doc.EncodingFlag = byte(flag)
+			err = checkEncodingFlag(doc.EncodingFlag, options)
+			if err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unknown setting in <?%s?>: %s", headerTarget, kv[0])
+		}
+	}
+	return nil
+}
+
+// base64Prefix marks strings that are written base64-encoded because
+// they are not valid XML text.
+const base64Prefix = "base64:"
+
+// EncodeString returns s as it should appear in an XML attribute. Strings
+// that contain invalid UTF-8 or characters XML 1.0 doesn't allow
+// (including NUL) are base64-encoded and prefixed with "base64:". So are
+// strings that happen to start with that prefix.
+func EncodeString(s string) string {
+	if strings.HasPrefix(s, base64Prefix) || !isXMLText(s) {
+		return base64Prefix + base64.StdEncoding.EncodeToString([]byte(s))
+	}
+	return s
+}
+
+// DecodeString reverses EncodeString.
+func DecodeString(s string) (string, error) {
+	if !strings.HasPrefix(s, base64Prefix) {
+		return s, nil
+	}
+	bs, err := base64.StdEncoding.DecodeString(s[len(base64Prefix):])
+	if err != nil {
+		return "", fmt.Errorf("bad base64 string \"%s\": %v", s, err)
+	}
+	return string(bs), nil
+}
+
+// isXMLText reports whether s is valid UTF-8 made only of characters
+// allowed by XML 1.0.
+func isXMLText(s string) bool {
+	if !utf8.ValidString(s) {
+		return false
+	}
+	for _, c := range s {
+		if c == 0x09 || c == 0x0a || c == 0x0d {
+			continue
+		}
+		if c < 0x20 || c > 0xd7ff && c < 0xe000 || c == 0xfffe || c == 0xffff {
+			return false
+		}
+	}
+	return true
+}
+
+// compactProp parses an element attribute written in the compact
+// property form, e.g. blendLength="float:0.5".
+func compactProp(a xml.Attr) (xmlProp, bool) {
+	if a.Name.Space != "" {
+		return xmlProp{}, false
+	}
+	i := strings.Index(a.Value, ":")
+	if i < 0 || !IsTypeName(a.Value[:i]) {
+		return xmlProp{}, false
+	}
+	return xmlProp{name: a.Name.Local, typ: a.Value[:i], value: a.Value[i+1:]}, true
+}
+
+// looksTyped reports whether an attribute that is not a compact property
+// looks like one with a misspelt type, e.g. a="flaot:1": the value starts
+// with a word and a colon, and the word is a type name but for one typo.
+// Other values with colons, like "urn:x" or "12:30", are plain XML.
+func looksTyped(a xml.Attr) bool {
+	i := strings.Index(a.Value, ":")
+	if a.Name.Space != "" || i <= 0 {
+		return false
+	}
+	word := strings.ToLower(a.Value[:i])
+	for _, typ := range TypeNames() {
+		if editDistance(word, typ) <= 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// editDistance returns the number of single-letter insertions, deletions,
+// substitutions and swaps of neighbours that turn a into b.
+func editDistance(a string, b string) int {
+	d := make([][]int, len(a)+1)
+	for i := range d {
+		d[i] = make([]int, len(b)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			d[i][j] = min3(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] && d[i-2][j-2]+1 < d[i][j] {
+				d[i][j] = d[i-2][j-2] + 1
+			}
+		}
+	}
+	return d[len(a)][len(b)]
+}
+
+func min3(a int, b int, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// isXMLName reports whether name can be used as an XML element or
+// attribute name as is. It is stricter than the XML spec: only ASCII
+// letters, digits, '_', '-' and '.' are allowed, and names starting
+// with "xml" are reserved.
+func isXMLName(name string) bool {
+	if name == "" || strings.HasPrefix(strings.ToLower(name), "xml") {
+		return false
+	}
+	for i, c := range name {
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' {
+			continue
+		}
+		if i > 0 && (c >= '0' && c <= '9' || c == '-' || c == '.') {
+			continue
+		}
+		return false
+	}
+	return true
+}
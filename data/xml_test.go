@@ -0,0 +1,31 @@
+package data
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReadXMLTypePrefixes(t *testing.T) {
+	tests := []struct {
+		xml  string
+		want string // error text, "" for an IBX1 document
+	}{
+		{`<Root a="float:1"/>`, ""},
+		{`<Root a="flaot:1"/>`, `property a: unknown type "flaot"`},
+		{`<Root a="strng:x"/>`, `property a: unknown type "strng"`},
+		{`<Root a="int8:1" b="flot:2"/>`, `property b: unknown type "flot"`},
+		{`<Root a="flaot:1" href="x"/>`, ErrPlainXML.Error()},
+		{`<Foo id="urn:x"/>`, ErrPlainXML.Error()},
+		{`<Foo time="12:30"/>`, ErrPlainXML.Error()},
+		{`<Foo href="http://x"/>`, ErrPlainXML.Error()},
+	}
+	for _, test := range tests {
+		_, err := ReadXML(strings.NewReader(test.xml), &Options{})
+		switch {
+		case test.want == "" && err != nil:
+			t.Errorf("%s: %v", test.xml, err)
+		case test.want != "" && (err == nil || !strings.Contains(err.Error(), test.want)):
+			t.Errorf("%s: got error %v, want %q", test.xml, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package presentation
+
+import (
+	"fmt"
+	"juce/fifa-ibx1/data"
+	"strconv"
+	"strings"
+)
+
+// CameraInstancesCollection holds cameras shared by several packages,
+// such as Player_Isolation.
+const CameraInstancesCollection = "CameraInstances.CameraInstancesCollection"
+
+// modifierKinds maps the key prefixes of camera modifiers to the
+// collections holding them.
+var modifierKinds = []struct{ prefix, collection string }{
+	{"zoom", "ZoomModifierCollection"},
+	{"tilt", "TiltModifierCollection"},
+	{"focal", "FocalDistModifierCollection"},
+}
+
+// Camera is a CameraInstance and the CameraNode it belongs to, if any.
+// Owner is the name of the CameraNode, or the CameraInstancesName of the
+// collection holding the camera.
+type Camera struct {
+	Name     string
+	Owner    string
+	Node     *data.Node
+	Instance *data.Node
+}
+
+// CameraParam is a parameter of a camera as listed by Params: a property
+// or, with Type "expr", an expression in infix form.
+type CameraParam struct {
+	Key   string
+	Type  string
+	Value string
+}
+
+// Cameras returns the cameras of doc in document order.
+func Cameras(doc *data.Document) []*Camera {
+	var cameras []*Camera
+	var walk func(n *data.Node, owner *data.Node)
+	walk = func(n *data.Node, owner *data.Node) {
+		switch n.ElementName() {
+		case CameraNode, CameraInstancesCollection:
+			owner = n
+		case CameraInstance:
+			c := &Camera{Name: StringProperty(n, "cameraName"), Instance: n}
+			if owner != nil && owner.ElementName() == CameraNode {
+				c.Node = owner
+				c.Owner = StringProperty(owner, "name")
+			} else if owner != nil {
+				c.Owner = StringProperty(owner, "CameraInstancesName")
+			}
+			cameras = append(cameras, c)
+			return
+		}
+		for _, child := range n.Children {
+			walk(child, owner)
+		}
+	}
+	if doc.Element != nil {
+		walk(doc.Element, nil)
+	}
+	return cameras
+}
+
+// ShortValue returns a property value for display: floats as in
+// expressions, without trailing zeros.
+func ShortValue(typ string, val string) string {
+	if typ == "float" {
+		if f, err := strconv.ParseFloat(val, 32); err == nil {
+			return formatFloat(float32(f))
+		}
+	}
+	return val
+}
+
+// params returns the properties and expressions of n, keys prefixed.
+func params(n *data.Node, prefix string, skip string) []CameraParam {
+	var ps []CameraParam
+	doc := n.Document()
+	for _, p := range n.Properties {
+		name := doc.Strings[p.Name]
+		if name == skip {
+			continue
+		}
+		typ, val, _ := n.Text(name)
+		ps = append(ps, CameraParam{prefix + name, typ, ShortValue(typ, val)})
+	}
+	for _, c := range n.Children {
+		if c.ElementName() != ExpressionProperty || len(c.Children) == 0 {
+			continue
+		}
+		text, err := FormatExpression(c.Children[0])
+		if err != nil {
+			text = "(" + err.Error() + ")"
+		}
+		ps = append(ps, CameraParam{prefix + StringProperty(c, "PropertyName"), "expr", text})
+	}
+	return ps
+}
+
+// Params lists everything camera set can change, with the keys it takes:
+// the properties and expressions of the CameraInstance (except
+// cameraName), then those of its CameraNode (except name), then those of
+// the modifiers as zoom.<key>, tilt[1].<key> and so on.
+func (c *Camera) Params() []CameraParam {
+	ps := params(c.Instance, "", "cameraName")
+	if c.Node != nil {
+		ps = append(ps, params(c.Node, "", "name")...)
+	}
+	for _, kind := range modifierKinds {
+		for i, m := range c.modifiers(kind.collection) {
+			prefix := kind.prefix + "."
+			if i > 0 {
+				prefix = fmt.Sprintf("%s[%d].", kind.prefix, i)
+			}
+			ps = append(ps, params(m, prefix, "")...)
+		}
+	}
+	return ps
+}
+
+// Get returns the value of a parameter for display, or "" if the camera
+// doesn't have it.
+func (c *Camera) Get(key string) string {
+	n, name, err := c.target(key)
+	if err != nil {
+		return ""
+	}
+	if ep := expressionProperty(n, name); ep != nil && len(ep.Children) > 0 {
+		text, _ := FormatExpression(ep.Children[0])
+		return text
+	}
+	typ, val, _ := n.Text(name)
+	return ShortValue(typ, val)
+}
+
+func (c *Camera) modifiers(collection string) []*data.Node {
+	if mc := Child(c.Instance, collection); mc != nil {
+		return mc.Children
+	}
+	return nil
+}
+
+// Modifiers summarizes the zoom, tilt and focal distance modifiers, e.g.
+// "zoom: zoomFinalValue=1.2 zoomEndTime=8.0".
+func (c *Camera) Modifiers() string {
+	var parts []string
+	for _, kind := range modifierKinds {
+		for _, m := range c.modifiers(kind.collection) {
+			var kv []string
+			for _, p := range params(m, "", "") {
+				kv = append(kv, p.Key+"="+p.Value)
+			}
+			parts = append(parts, kind.prefix+": "+strings.Join(kv, " "))
+		}
+	}
+	return strings.Join(parts, "; ")
+}
+
+// target finds the node a key refers to and the property or expression
+// name within it. The CameraInstance is searched before the CameraNode.
+func (c *Camera) target(key string) (*data.Node, string, error) {
+	for _, kind := range modifierKinds {
+		if !strings.HasPrefix(key, kind.prefix+".") && !strings.HasPrefix(key, kind.prefix+"[") {
+			continue
+		}
+		rest := key[len(kind.prefix):]
+		i := 0
+		if strings.HasPrefix(rest, "[") {
+			end := strings.Index(rest, "].")
+			if end < 0 {
+				return nil, "", fmt.Errorf("bad key %s", key)
+			}
+			var err error
+			i, err = strconv.Atoi(rest[1:end])
+			if err != nil {
+				return nil, "", fmt.Errorf("bad key %s", key)
+			}
+			rest = rest[end+1:]
+		}
+		ms := c.modifiers(kind.collection)
+		if i < 0 || i >= len(ms) {
+			return nil, "", fmt.Errorf("camera %s has %d %s modifiers", c.Name, len(ms), kind.prefix)
+		}
+		return ms[i], rest[1:], nil
+	}
+	if c.Node != nil && !has(c.Instance, key) && has(c.Node, key) {
+		return c.Node, key, nil
+	}
+	return c.Instance, key, nil
+}
+
+// has reports whether n has a property or an expression named name.
+func has(n *data.Node, name string) bool {
+	return n.Property(name) != nil || expressionProperty(n, name) != nil
+}
+
+func expressionProperty(n *data.Node, name string) *data.Node {
+	for _, c := range n.Children {
+		if c.ElementName() == ExpressionProperty && StringProperty(c, "PropertyName") == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// Set changes a parameter. Properties keep their type unless the value
+// is written as type:value, which is also how new properties are added.
+// Expressions are given in infix form, as for ibx1 expr.
+func (c *Camera) Set(key string, value string) error {
+	if key == "cameraName" || key == "name" {
+		return fmt.Errorf("%s cannot be set", key)
+	}
+	n, name, err := c.target(key)
+	if err != nil {
+		return err
+	}
+	if ep := expressionProperty(n, name); ep != nil {
+		expr, err := ParseExpression(n.Document(), value)
+		if err != nil {
+			return fmt.Errorf("%s: %v", key, err)
+		}
+		ep.Children = []*data.Node{expr}
+		return nil
+	}
+	typ, _, ok := n.Text(name)
+	if i := strings.Index(value, ":"); i >= 0 && data.IsTypeName(value[:i]) {
+		typ, value = value[:i], value[i+1:]
+	} else if !ok {
+		return fmt.Errorf("camera %s has no %s: give its type to add it, e.g. %s=float:%s", c.Name, key, key, value)
+	}
+	err = n.SetText(name, typ, value)
+	if err != nil {
+		return fmt.Errorf("%s: %v", key, err)
+	}
+	return nil
+}
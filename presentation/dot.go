@@ -0,0 +1,181 @@
+package presentation
+
+import (
+	"fmt"
+	"io"
+	"juce/fifa-ibx1/data"
+	"strings"
+)
+
+var dotEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// dotLabel joins label lines into a quoted DOT string.
+func dotLabel(lines []string) string {
+	arr := make([]string, len(lines))
+	for i, line := range lines {
+		arr[i] = dotEscaper.Replace(line)
+	}
+	return `"` + strings.Join(arr, `\n`) + `"`
+}
+
+type dotWriter struct {
+	w      io.Writer
+	nextId int
+	err    error
+}
+
+func (d *dotWriter) printf(format string, args ...interface{}) {
+	if d.err == nil {
+		_, d.err = fmt.Fprintf(d.w, format, args...)
+	}
+}
+
+func (d *dotWriter) node(lines []string, attrs string) string {
+	id := fmt.Sprintf("n%d", d.nextId)
+	d.nextId++
+	d.printf("    %s [label=%s%s];\n", id, dotLabel(lines), attrs)
+	return id
+}
+
+func (d *dotWriter) edge(from string, to string, label string, attrs string) {
+	if label != "" {
+		attrs = fmt.Sprintf(" [label=%s%s]", dotLabel([]string{label}), attrs)
+	} else if attrs != "" {
+		attrs = " [" + attrs[2:] + "]"
+	}
+	d.printf("    %s -> %s%s;\n", from, to, attrs)
+}
+
+// WriteDot writes the ProcessGraphs of doc as a Graphviz digraph, one
+// cluster per graph. Flow nodes are labelled with their type and key
+// properties. Edges follow the tree: plain edges for children and
+// ChildrenList entries (numbered for SerialNodes, whose children run in
+// order), case values on the branches of a SwitchCaseNode, dotted edges to
+// selectors and dashed edges to actions.
+func WriteDot(w io.Writer, doc *data.Document) error {
+	d := &dotWriter{w: w}
+	d.printf("digraph presentation {\n")
+	d.printf("  node [shape=box, fontname=\"Helvetica\"];\n")
+	d.printf("  edge [fontname=\"Helvetica\", fontsize=10];\n")
+	if doc.Element != nil {
+		for i, g := range doc.Element.Find(ProcessGraph) {
+			d.printf("  subgraph cluster_%d {\n", i)
+			d.printf("    label=%s;\n", dotLabel([]string{StringProperty(g, "name")}))
+			d.visit(g)
+			d.printf("  }\n")
+		}
+	}
+	d.printf("}\n")
+	return d.err
+}
+
+// visit writes n and the flow below it, and returns the id of n.
+func (d *dotWriter) visit(n *data.Node) string {
+	name := n.ElementName()
+	attrs := ""
+	switch name {
+	case ProcessGraph:
+		attrs = ", shape=doubleoctagon"
+	case CameraNode:
+		attrs = ", style=filled, fillcolor=lightblue"
+	case Selector:
+		attrs = ", shape=diamond"
+	case SwitchCaseNode, CaseNode:
+		attrs = ", shape=hexagon"
+	}
+	id := d.node(nodeLabel(n), attrs)
+
+	var flow []*data.Node
+	for _, c := range n.Children {
+		switch c.ElementName() {
+		case ChildrenList:
+			flow = append(flow, c.Children...)
+		case ActionCollection:
+			for _, a := range c.Children {
+				aid := d.node(actionLabel(a), ", shape=note")
+				d.edge(id, aid, "", ", style=dashed")
+			}
+		default:
+			flow = append(flow, c)
+		}
+	}
+	step := 0
+	for _, c := range flow {
+		cname := c.ElementName()
+		if !IsFlowNode(cname) {
+			continue
+		}
+		cid := d.visit(c)
+		switch {
+		case cname == Selector:
+			d.edge(id, cid, "", ", style=dotted")
+		case cname == CaseNode:
+			d.edge(id, cid, caseLabel(c), "")
+		case name == SerialNode:
+			step++
+			d.edge(id, cid, fmt.Sprintf("%d", step), "")
+		default:
+			d.edge(id, cid, "", "")
+		}
+	}
+	return id
+}
+
+func nodeLabel(n *data.Node) []string {
+	name := n.ElementName()
+	lines := []string{ShortName(name)}
+	if s := StringProperty(n, "name"); s != "" {
+		lines = append(lines, s)
+	}
+	switch name {
+	case CameraNode:
+		lines = append(lines, CameraNames(n)...)
+	case SwitchCaseNode:
+		lines = append(lines, "switch "+expressionLabel(Expression(n, "switchExpression")))
+	case CaseNode:
+		lines = append(lines, caseLabel(n))
+	case Selector:
+		if len(n.Children) > 0 {
+			lines = append(lines, expressionLabel(n.Children[0]))
+		}
+	}
+	return lines
+}
+
+// caseLabel describes the branch of a CaseNode. Cases without a
+// caseExpression are taken when no other case matches.
+func caseLabel(n *data.Node) string {
+	expr := Expression(n, "caseExpression")
+	if expr == nil {
+		return "default"
+	}
+	return "case " + expressionLabel(expr)
+}
+
+// actionLabel shows the type of an action and its string properties,
+// which name the packages and variables it works on.
+func actionLabel(n *data.Node) []string {
+	lines := []string{ShortName(n.ElementName())}
+	doc := n.Document()
+	for _, p := range n.Properties {
+		pname := doc.Strings[p.Name]
+		typ, val, _ := n.Text(pname)
+		if typ == "string" && pname != "DisplayName" && val != "" {
+			lines = append(lines, pname+"="+val)
+		}
+	}
+	return lines
+}
+
+// expressionLabel gives the infix text of an expression subtree, or its
+// DisplayName if it can't be formatted.
+func expressionLabel(n *data.Node) string {
+	if n == nil {
+		return "?"
+	}
+	s, err := FormatExpression(n)
+	if err != nil {
+		return StringProperty(n, "DisplayName")
+	}
+	return s
+}
@@ -0,0 +1,554 @@
+package presentation
+
+import (
+	"fmt"
+	"juce/fifa-ibx1/data"
+	"strconv"
+	"strings"
+)
+
+// Element names of expression trees.
+const (
+	Logic          = "ExpressionTree.Logic"
+	Comparison     = "ExpressionTree.Comparison"
+	Variable       = "ExpressionTree.Variable"
+	ActionVariable = "Muse.Fifa.ActionVariable"
+	EventVariable  = "Muse.Fifa.EventVariable"
+	QueryVariable  = "Muse.FifaQueryVariable"
+)
+
+// IsExpression reports whether elements with this name are part of an
+// expression tree.
+func IsExpression(name string) bool {
+	switch name {
+	case Logic, Comparison, Variable, ActionVariable, EventVariable, QueryVariable:
+		return true
+	}
+	return false
+}
+
+// Logic operators, indexed by the operator property.
+var logicOperators = []string{"&&", "||"}
+var logicNames = []string{"And", "Or"}
+
+// Comparison operators, indexed by the operator property, and the way
+// they are written in DisplayName.
+var comparisonOperators = []string{"==", "!=", "<", "<=", ">", ">="}
+var comparisonNames = []string{"=", "!=", "<", "<=", ">", ">="}
+
+// defaultIntType is the type an integer literal gets unless another one
+// is written out: small non-negative numbers are int8, as in the game
+// files, everything else int32.
+func defaultIntType(v int64) string {
+	if v >= 0 && v <= 127 {
+		return "int8"
+	}
+	return "int32"
+}
+
+// FormatExpression renders an expression subtree as infix text:
+//
+//	@FlowEnterKitSelect._COUNT_ > 0 && (?FESettings.ScreenCameraDepth == StadiumFECameras:STADIUM_FE_CAMERA_PRE_KITSELECT || IsReplay == 1)
+//
+// Logic nodes become && and || chains (nested ones in parentheses, empty
+// or single-operand ones as And(...) and Or(...)), comparisons use ==, !=,
+// <, <=, > and >=, and operands are written as:
+//
+//	Name                action variable
+//	@Event.Parameter    event variable, with :type unless it is int
+//	?Table.Parameter    query variable, with :type unless it is int
+//	1, int32:1          integer, with its type unless it is the default
+//	0.5, true           float, bool
+//	Type:Value          enum, followed by (n) unless its number is -1
+//
+// ParseExpression reads the text back into the same nodes.
+func FormatExpression(n *data.Node) (string, error) {
+	switch n.ElementName() {
+	case Logic:
+		op, err := operator(n, logicOperators)
+		if err != nil {
+			return "", err
+		}
+		arr := make([]string, len(n.Children))
+		for i, c := range n.Children {
+			s, err := FormatExpression(c)
+			if err != nil {
+				return "", err
+			}
+			if c.ElementName() == Logic && len(c.Children) > 1 {
+				s = "(" + s + ")"
+			}
+			arr[i] = s
+		}
+		if len(arr) < 2 {
+			return logicNames[op] + "(" + strings.Join(arr, "") + ")", nil
+		}
+		return strings.Join(arr, " "+logicOperators[op]+" "), nil
+	case Comparison:
+		op, err := operator(n, comparisonOperators)
+		if err != nil {
+			return "", err
+		}
+		if len(n.Children) != 2 {
+			return "", fmt.Errorf("comparison with %d operands", len(n.Children))
+		}
+		var arr [2]string
+		for i, c := range n.Children {
+			if c.ElementName() == Logic || c.ElementName() == Comparison {
+				s, err := FormatExpression(c)
+				if err != nil {
+					return "", err
+				}
+				arr[i] = "(" + s + ")"
+				continue
+			}
+			s, err := formatOperand(c)
+			if err != nil {
+				return "", err
+			}
+			arr[i] = s
+		}
+		return arr[0] + " " + comparisonOperators[op] + " " + arr[1], nil
+	}
+	return formatOperand(n)
+}
+
+func operator(n *data.Node, operators []string) (int, error) {
+	typ, val, ok := n.Text("operator")
+	op, err := strconv.Atoi(val)
+	if !ok || typ != "int8" || err != nil || op < 0 || op >= len(operators) {
+		return 0, fmt.Errorf("%s: unsupported operator %s %s", ShortName(n.ElementName()), typ, val)
+	}
+	return op, nil
+}
+
+func typeSuffix(typ string) string {
+	if typ == "int" {
+		return ""
+	}
+	return ":" + typ
+}
+
+func formatOperand(n *data.Node) (string, error) {
+	switch n.ElementName() {
+	case ActionVariable:
+		return StringProperty(n, "VariableName"), nil
+	case EventVariable:
+		return "@" + StringProperty(n, "Event") + "." + StringProperty(n, "Parameter") +
+			typeSuffix(StringProperty(n, "Type")), nil
+	case QueryVariable:
+		return "?" + StringProperty(n, "Table") + "." + StringProperty(n, "Parameter") +
+			typeSuffix(StringProperty(n, "Type")), nil
+	case Variable:
+		return formatLiteral(n)
+	}
+	return "", fmt.Errorf("unsupported expression element %s", n.ElementName())
+}
+
+func formatLiteral(n *data.Node) (string, error) {
+	if len(n.Properties) == 0 {
+		return "", fmt.Errorf("variable without a value")
+	}
+	doc := n.Document()
+	name := doc.Strings[n.Properties[0].Name]
+	typ, val, _ := n.Text(name)
+	switch {
+	case name == "int" && len(n.Properties) == 3 && n.Property("enumType") != nil && n.Property("enumValue") != nil:
+		s := StringProperty(n, "enumType") + ":" + StringProperty(n, "enumValue")
+		if typ != "int32" || val != "-1" {
+			s += "(" + formatInt(typ, val) + ")"
+		}
+		return s, nil
+	case len(n.Properties) > 1:
+	case name == "int" && strings.HasPrefix(strings.TrimPrefix(typ, "u"), "int"):
+		return formatInt(typ, val), nil
+	case name == "float" && typ == "float":
+		return formatFloat(n.Value(name).(data.Float).Value), nil
+	case name == "bool" && typ == "bool":
+		return val, nil
+	}
+	return "", fmt.Errorf("unsupported variable %v", n)
+}
+
+func formatInt(typ string, val string) string {
+	v, _ := strconv.ParseInt(val, 10, 64)
+	if typ == defaultIntType(v) {
+		return val
+	}
+	return typ + ":" + val
+}
+
+// formatFloat writes v so that it reads back as a float: 15.0, 0.25.
+func formatFloat(v float32) string {
+	s := strconv.FormatFloat(float64(v), 'f', -1, 32)
+	if !strings.ContainsAny(s, ".") {
+		s += ".0"
+	}
+	return s
+}
+
+// displayName is what the game's editor stores in the DisplayName of
+// comparisons: the operands as the editor shows them.
+func displayName(n *data.Node) string {
+	switch n.ElementName() {
+	case Variable:
+		if n.Property("enumType") != nil {
+			return "Enum " + StringProperty(n, "enumType") + ":" + StringProperty(n, "enumValue")
+		}
+		if len(n.Properties) > 0 {
+			name := n.Document().Strings[n.Properties[0].Name]
+			val := StringProperty(n, name)
+			if f, ok := n.Value(name).(data.Float); ok {
+				val = strconv.FormatFloat(float64(f.Value), 'g', -1, 32)
+			}
+			return name + " " + val
+		}
+	case ActionVariable:
+		return StringProperty(n, "VariableName")
+	}
+	return StringProperty(n, "DisplayName")
+}
+
+// exprParser is a recursive descent parser over the tokens of an
+// expression.
+type exprParser struct {
+	doc     *data.Document
+	tokens  []string
+	offsets []int // byte offset of each token in the text
+	end     int   // length of the text
+	pos     int
+	last    int // index of the token last read, len(tokens) at the end
+}
+
+// ParseExpression parses infix text written as described for
+// FormatExpression and returns the root of the new expression subtree,
+// built in doc.
+func ParseExpression(doc *data.Document, text string) (*data.Node, error) {
+	tokens, offsets, err := tokenize(text)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{doc: doc, tokens: tokens, offsets: offsets, end: len(text)}
+	n, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, p.errorf("unexpected \"%s\"", p.next())
+	}
+	return n, nil
+}
+
+func isIdentChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '.' || c == '-'
+}
+
+// tokenize splits text into tokens and returns them with their offsets.
+func tokenize(text string) ([]string, []int, error) {
+	var tokens []string
+	var offsets []int
+	for i := 0; i < len(text); {
+		c := text[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+		case strings.HasPrefix(text[i:], "&&") || strings.HasPrefix(text[i:], "||") ||
+			strings.HasPrefix(text[i:], "==") || strings.HasPrefix(text[i:], "!=") ||
+			strings.HasPrefix(text[i:], "<=") || strings.HasPrefix(text[i:], ">="):
+			tokens = append(tokens, text[i:i+2])
+			offsets = append(offsets, i)
+			i += 2
+		case strings.IndexByte("()<>=:,@?", c) >= 0:
+			tokens = append(tokens, text[i:i+1])
+			offsets = append(offsets, i)
+			i++
+		case isIdentChar(c):
+			j := i
+			for j < len(text) && isIdentChar(text[j]) {
+				j++
+			}
+			tokens = append(tokens, text[i:j])
+			offsets = append(offsets, i)
+			i = j
+		default:
+			return nil, nil, fmt.Errorf("unexpected character '%c' at column %d", c, i+1)
+		}
+	}
+	return tokens, offsets, nil
+}
+
+func (p *exprParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *exprParser) next() string {
+	t := p.peek()
+	p.last = p.pos
+	if t != "" {
+		p.pos++
+	}
+	return t
+}
+
+// errorf returns an error at the column of the token last read, or past
+// the end of the text if there was none left.
+func (p *exprParser) errorf(format string, args ...interface{}) error {
+	column := p.end + 1
+	if p.last < len(p.offsets) {
+		column = p.offsets[p.last] + 1
+	}
+	return fmt.Errorf("%s at column %d", fmt.Sprintf(format, args...), column)
+}
+
+func (p *exprParser) expect(t string) error {
+	if got := p.next(); got != t {
+		if got == "" {
+			got = "end of expression"
+		}
+		return p.errorf("expected \"%s\", got \"%s\"", t, got)
+	}
+	return nil
+}
+
+func (p *exprParser) logic(op int, children []*data.Node) *data.Node {
+	n := p.doc.NewNode(Logic).
+		SetInt8("operator", int8(op)).
+		SetString("DisplayName", logicNames[op])
+	n.Children = children
+	return n
+}
+
+func (p *exprParser) or() (*data.Node, error) {
+	return p.chain(1, p.and)
+}
+
+func (p *exprParser) and() (*data.Node, error) {
+	return p.chain(0, p.comparison)
+}
+
+// chain parses operands joined by the logic operator op into one Logic
+// node. A single operand is returned as is.
+func (p *exprParser) chain(op int, operand func() (*data.Node, error)) (*data.Node, error) {
+	first, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	children := []*data.Node{first}
+	for p.peek() == logicOperators[op] {
+		p.next()
+		n, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, n)
+	}
+	if len(children) == 1 {
+		return first, nil
+	}
+	return p.logic(op, children), nil
+}
+
+func (p *exprParser) comparison() (*data.Node, error) {
+	left, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	op := -1
+	t := p.peek()
+	if t == "=" {
+		t = "=="
+	}
+	for i, s := range comparisonOperators {
+		if s == t {
+			op = i
+		}
+	}
+	if op < 0 {
+		return left, nil
+	}
+	p.next()
+	right, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	n := p.doc.NewNode(Comparison).
+		SetInt8("operator", int8(op)).
+		SetString("DisplayName", displayName(left)+" "+comparisonNames[op]+" "+displayName(right))
+	n.Children = []*data.Node{left, right}
+	return n, nil
+}
+
+func (p *exprParser) primary() (*data.Node, error) {
+	t := p.next()
+	switch {
+	case t == "(":
+		n, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		return n, p.expect(")")
+	case (t == "And" || t == "Or") && p.peek() == "(":
+		p.next()
+		op := 0
+		if t == "Or" {
+			op = 1
+		}
+		var children []*data.Node
+		for p.peek() != ")" {
+			if len(children) > 0 {
+				if err := p.expect(","); err != nil {
+					return nil, err
+				}
+			}
+			n, err := p.or()
+			if err != nil {
+				return nil, err
+			}
+			children = append(children, n)
+		}
+		p.next()
+		return p.logic(op, children), nil
+	case t == "@" || t == "?":
+		return p.variable(t)
+	case t == "true" || t == "false":
+		return p.doc.NewNode(Variable).SetBool("bool", t == "true"), nil
+	case t == "":
+		return nil, p.errorf("unexpected end of expression")
+	case !isIdentChar(t[0]):
+		return nil, p.errorf("unexpected \"%s\"", t)
+	case data.IsTypeName(t) && p.peek() == ":":
+		p.next()
+		return p.number(t, p.next())
+	case t[0] == '-' || t[0] >= '0' && t[0] <= '9':
+		return p.number("", t)
+	case p.peek() == ":":
+		return p.enum(t)
+	}
+	return p.doc.NewNode(ActionVariable).SetString("VariableName", t), nil
+}
+
+// variable parses an event (@Event.Parameter) or query (?Table.Parameter)
+// variable, with an optional :type.
+func (p *exprParser) variable(sigil string) (*data.Node, error) {
+	name := p.next()
+	i := strings.Index(name, ".")
+	if i <= 0 || i == len(name)-1 {
+		return nil, p.errorf("expected %sName.Parameter, got \"%s%s\"", sigil, sigil, name)
+	}
+	typ := "int"
+	if p.peek() == ":" {
+		p.next()
+		typ = p.next()
+	}
+	if sigil == "@" {
+		return p.doc.NewNode(EventVariable).
+			SetString("DisplayName", "#FifaEvent#Live."+name).
+			SetString("Type", typ).
+			SetString("Event", name[:i]).
+			SetString("Parameter", name[i+1:]), nil
+	}
+	return p.doc.NewNode(QueryVariable).
+		SetString("DisplayName", "#FifaQuery#"+name[:i]+"[]."+name[i+1:]+":"+typ).
+		SetString("Type", typ).
+		SetString("Parameter", name[i+1:]).
+		SetString("Table", name[:i]), nil
+}
+
+// number parses an integer or float literal. typ is empty unless it was
+// written out.
+func (p *exprParser) number(typ string, val string) (*data.Node, error) {
+	n := p.doc.NewNode(Variable)
+	if strings.ContainsAny(val, ".eE") || typ == "float" {
+		f, err := strconv.ParseFloat(val, 32)
+		if err != nil {
+			return nil, p.errorf("bad number \"%s\"", val)
+		}
+		return n.SetFloat("float", float32(f)), nil
+	}
+	v, err := strconv.ParseInt(val, 10, 64)
+	if err != nil {
+		return nil, p.errorf("bad number \"%s\"", val)
+	}
+	if typ == "" {
+		typ = defaultIntType(v)
+	}
+	if err := n.SetText("int", typ, val); err != nil {
+		return nil, p.errorf("%v", err)
+	}
+	return n, nil
+}
+
+// enum parses Type:Value with an optional (n) or (type:n).
+func (p *exprParser) enum(enumType string) (*data.Node, error) {
+	p.next()
+	enumValue := p.next()
+	if enumValue == "" || !isIdentChar(enumValue[0]) {
+		return nil, p.errorf("expected enum value after \"%s:\"", enumType)
+	}
+	typ, val := "int32", "-1"
+	if p.peek() == "(" {
+		p.next()
+		typ, val = "", p.next()
+		if p.peek() == ":" {
+			p.next()
+			typ, val = val, p.next()
+		}
+		v, err := strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			return nil, p.errorf("bad number \"%s\"", val)
+		}
+		if typ == "" {
+			typ = defaultIntType(v)
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+	}
+	n := p.doc.NewNode(Variable)
+	if err := n.SetText("int", typ, val); err != nil {
+		return nil, p.errorf("%v", err)
+	}
+	return n.SetString("enumType", enumType).SetString("enumValue", enumValue), nil
+}
+
+// ExpressionRef is an expression subtree found in a document, with a
+// readable path to it.
+type ExpressionRef struct {
+	Node *data.Node
+	Path string
+}
+
+// Expressions returns the roots of all expression subtrees of doc in
+// document order. Paths name the elements on the way down, with their
+// name property or, for FIFAExpressionProperty, their PropertyName:
+// ProcessGraph[story_main]/DecisionNode[default]/Selector.
+func Expressions(doc *data.Document) []*ExpressionRef {
+	var refs []*ExpressionRef
+	var walk func(n *data.Node, path []string)
+	walk = func(n *data.Node, path []string) {
+		name := n.ElementName()
+		if IsExpression(name) {
+			refs = append(refs, &ExpressionRef{n, strings.Join(path, "/")})
+			return
+		}
+		step := ShortName(name)
+		if name == ExpressionProperty {
+			step = StringProperty(n, "PropertyName")
+		} else if s := StringProperty(n, "name"); s != "" {
+			step += "[" + s + "]"
+		}
+		if n != doc.Element {
+			path = append(path, step)
+		}
+		for _, c := range n.Children {
+			walk(c, path[:len(path):len(path)])
+		}
+	}
+	if doc.Element != nil {
+		walk(doc.Element, nil)
+	}
+	return refs
+}
@@ -0,0 +1,163 @@
+package presentation
+
+import (
+	"juce/fifa-ibx1/data"
+	"strings"
+	"testing"
+)
+
+func TestExpressionRoundTrip(t *testing.T) {
+	tests := []struct {
+		text string
+		want string // canonical form, if different from text
+	}{
+		{"IsReplay", ""},
+		{"IsReplay == 1", ""},
+		{"IsReplay = 1", "IsReplay == 1"},
+		{"a != 0 && b < 5 && c <= -2", ""},
+		{"a > 200 || b >= int32:3", ""},
+		{"a > int32:200", "a > 200"},
+		{"a == int16:3", ""},
+		{"a == uint8:200", ""},
+		{"a == 0.5 && b == 15.0 && c == 2", ""},
+		{"a == float:2", "a == 2.0"},
+		{"a == true || b == false", ""},
+		{"@FlowEnterKitSelect._COUNT_ > 0", ""},
+		{"@Camera.Name:string == Mode", ""},
+		{"?FESettings.ScreenCameraDepth:int == 3", "?FESettings.ScreenCameraDepth == 3"},
+		{"?FESettings.Zoom:float > 0.25", ""},
+		{"?FESettings.ScreenCameraDepth == StadiumFECameras:STADIUM_FE_CAMERA_PRE_KITSELECT", ""},
+		{"Mode == Cameras:TOP(3)", ""},
+		{"Mode == Cameras:TOP(int8:3)", "Mode == Cameras:TOP(3)"},
+		{"Mode == Cameras:TOP(int32:3)", ""},
+		{"Mode == Cameras:TOP(int8:-1)", ""},
+		{"Mode == Cameras:TOP(int32:-1)", "Mode == Cameras:TOP"},
+		{"a == 1 && (b == 2 || c == 3)", ""},
+		{"a == 1 && b == 2 || c == 3", "(a == 1 && b == 2) || c == 3"},
+		{"(a == 1)", "a == 1"},
+		{"And()", ""},
+		{"Or(a == 1)", ""},
+		{"And(a == 1, b == 2)", "a == 1 && b == 2"},
+		{"(a == 1) == (b == 2)", ""},
+	}
+	for _, test := range tests {
+		doc := data.NewDocument()
+		n, err := ParseExpression(doc, test.text)
+		if err != nil {
+			t.Errorf("%s: %v", test.text, err)
+			continue
+		}
+		want := test.want
+		if want == "" {
+			want = test.text
+		}
+		got, err := FormatExpression(n)
+		if err != nil {
+			t.Errorf("%s: format: %v", test.text, err)
+		} else if got != want {
+			t.Errorf("%s: got %s, want %s", test.text, got, want)
+		}
+	}
+}
+
+// shape writes the structure of an expression tree, with Logic nodes as
+// And(...) or Or(...) and comparisons as Cmp(...).
+func shape(n *data.Node) string {
+	var name string
+	switch n.ElementName() {
+	case Logic:
+		name = logicNames[n.Value("operator").(data.Int8).Value]
+	case Comparison:
+		name = "Cmp"
+	default:
+		s, _ := FormatExpression(n)
+		return s
+	}
+	arr := make([]string, len(n.Children))
+	for i, c := range n.Children {
+		arr[i] = shape(c)
+	}
+	return name + "(" + strings.Join(arr, ", ") + ")"
+}
+
+func TestExpressionPrecedence(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"a == 1 || b == 2 && c == 3", "Or(Cmp(a, 1), And(Cmp(b, 2), Cmp(c, 3)))"},
+		{"a == 1 && b == 2 || c == 3", "Or(And(Cmp(a, 1), Cmp(b, 2)), Cmp(c, 3))"},
+		{"a == 1 && (b == 2 || c == 3)", "And(Cmp(a, 1), Or(Cmp(b, 2), Cmp(c, 3)))"},
+		{"a || b || c && d", "Or(a, b, And(c, d))"},
+		{"a && b && c", "And(a, b, c)"},
+		{"Or(a, b && c)", "Or(a, And(b, c))"},
+	}
+	for _, test := range tests {
+		n, err := ParseExpression(data.NewDocument(), test.text)
+		if err != nil {
+			t.Errorf("%s: %v", test.text, err)
+			continue
+		}
+		if got := shape(n); got != test.want {
+			t.Errorf("%s: got %s, want %s", test.text, got, test.want)
+		}
+	}
+}
+
+func TestExpressionLiterals(t *testing.T) {
+	doc := data.NewDocument()
+	n, err := ParseExpression(doc, "?FESettings.Depth == StadiumFECameras:PRE_KITSELECT(int8:4)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, v := n.Children[0], n.Children[1]
+	for _, c := range []struct {
+		node *data.Node
+		name string
+		want string
+	}{
+		{q, "Table", "FESettings"},
+		{q, "Parameter", "Depth"},
+		{q, "Type", "int"},
+		{q, "DisplayName", "#FifaQuery#FESettings[].Depth:int"},
+		{v, "enumType", "StadiumFECameras"},
+		{v, "enumValue", "PRE_KITSELECT"},
+		{n, "DisplayName", "#FifaQuery#FESettings[].Depth:int = Enum StadiumFECameras:PRE_KITSELECT"},
+	} {
+		if got := StringProperty(c.node, c.name); got != c.want {
+			t.Errorf("%s: got %q, want %q", c.name, got, c.want)
+		}
+	}
+	if typ, val, _ := v.Text("int"); typ != "int8" || val != "4" {
+		t.Errorf("enum number: got %s:%s, want int8:4", typ, val)
+	}
+}
+
+func TestExpressionErrors(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"", "unexpected end of expression at column 1"},
+		{"a == ", "unexpected end of expression at column 6"},
+		{"a + 1", "unexpected character '+' at column 3"},
+		{"a == 1 b", "unexpected \"b\" at column 8"},
+		{"a == )", "unexpected \")\" at column 6"},
+		{"(a == 1", "expected \")\", got \"end of expression\" at column 8"},
+		{"And(a b)", "expected \",\", got \"b\" at column 7"},
+		{"@Event == 1", "expected @Name.Parameter, got \"@Event\" at column 2"},
+		{"?Table. == 1", "expected ?Name.Parameter, got \"?Table.\" at column 2"},
+		{"a == 1x", "bad number \"1x\" at column 6"},
+		{"a == Cameras:(1)", "expected enum value after \"Cameras:\" at column 14"},
+		{"a == Cameras:TOP(x)", "bad number \"x\" at column 18"},
+		{"a == Cameras:TOP(1", "expected \")\", got \"end of expression\" at column 19"},
+	}
+	for _, test := range tests {
+		_, err := ParseExpression(data.NewDocument(), test.text)
+		if err == nil {
+			t.Errorf("%q: no error, want %q", test.text, test.want)
+		} else if !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%q: got %q, want %q", test.text, err, test.want)
+		}
+	}
+}
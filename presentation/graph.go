@@ -0,0 +1,99 @@
+// Package presentation understands the FIFA presentation graphs stored in
+// IBX1 files: ProcessGraph trees of serial, switch/case, camera and
+// decision nodes, and the expressions attached to them.
+package presentation
+
+import (
+	"juce/fifa-ibx1/data"
+	"strings"
+)
+
+// Element names used by the presentation graphs.
+const (
+	ProcessGraph       = "ProcessGraph.ProcessGraph"
+	SerialNode         = "ProcessGraph.SerialNode"
+	SwitchCaseNode     = "FIFAPresentationNodes.SwitchCase.SwitchCaseNode"
+	CaseNode           = "FIFAPresentationNodes.SwitchCase.CaseNode"
+	CameraNode         = "FIFAPresentationNodes.CameraNode"
+	DecisionNode       = "DecisionTree.DecisionNode"
+	Selector           = "DecisionTree.Selector"
+	WaitNode           = "DecisionTree.WaitNode"
+	ActionCollection   = "DecisionTree.ActionCollection"
+	ChildrenList       = "ChildrenList"
+	CameraCollection   = "CameraCollection"
+	CameraInstance     = "CameraInstance"
+	ExpressionProperty = "FIFAExpressionProperty"
+)
+
+// IsFlowNode reports whether elements with this name take part in the
+// control flow of a graph, as opposed to the data hanging off them
+// (cameras, targets, expressions and the lists holding them).
+func IsFlowNode(name string) bool {
+	switch {
+	case name == ActionCollection:
+		return false
+	case strings.HasPrefix(name, "ProcessGraph."),
+		strings.HasPrefix(name, "FIFAPresentationNodes."),
+		strings.HasPrefix(name, "DecisionTree."):
+		return true
+	}
+	return false
+}
+
+// IsAction reports whether elements with this name are actions, which
+// live in the ActionCollection of a decision node.
+func IsAction(name string) bool {
+	return strings.HasPrefix(name, "Muse.") && strings.HasSuffix(name, "Action")
+}
+
+// ShortName strips the namespace from an element name:
+// "FIFAPresentationNodes.CameraNode" becomes "CameraNode".
+func ShortName(name string) string {
+	return name[strings.LastIndex(name, ".")+1:]
+}
+
+// StringProperty returns the value of a property as text, or "" if n
+// doesn't have it.
+func StringProperty(n *data.Node, name string) string {
+	_, val, _ := n.Text(name)
+	return val
+}
+
+// Child returns the first child of n with the given element name, or nil.
+func Child(n *data.Node, name string) *data.Node {
+	for _, c := range n.Children {
+		if c.ElementName() == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// Expression returns the expression stored in the
+// FIFAExpressionProperty of n with the given PropertyName, such as
+// "caseExpression", or nil.
+func Expression(n *data.Node, propertyName string) *data.Node {
+	for _, c := range n.Children {
+		if c.ElementName() != ExpressionProperty || StringProperty(c, "PropertyName") != propertyName {
+			continue
+		}
+		if len(c.Children) > 0 {
+			return c.Children[0]
+		}
+	}
+	return nil
+}
+
+// CameraNames returns the cameraName of every CameraInstance of a
+// CameraNode.
+func CameraNames(n *data.Node) []string {
+	var names []string
+	if cc := Child(n, CameraCollection); cc != nil {
+		for _, c := range cc.Children {
+			if c.ElementName() == CameraInstance {
+				names = append(names, StringProperty(c, "cameraName"))
+			}
+		}
+	}
+	return names
+}
@@ -0,0 +1,313 @@
+package presentation
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"juce/fifa-ibx1/data"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Kinds of references between files.
+const (
+	RefFile            = "file"            // a path: File filename, museindex package, actvarfile, camgroupfile
+	RefPackage         = "package"         // a ProcessGraph by name
+	RefActionVariables = "actionvariables" // an ActionVariablesCollection by name
+)
+
+// Reference is a reference from one file of a folder to another. To is
+// empty for dangling references.
+type Reference struct {
+	From   string `json:"from"`
+	Kind   string `json:"kind"`
+	Source string `json:"source"` // element and property or attribute holding it
+	Value  string `json:"value"`
+	To     string `json:"to,omitempty"`
+}
+
+// RefGraph is the graph of references between the files of a folder.
+// File names are relative to the folder, with forward slashes.
+type RefGraph struct {
+	Files   []string     `json:"files"`
+	Refs    []*Reference `json:"refs"`
+	Orphans []string     `json:"orphans"`
+	Errors  []string     `json:"errors,omitempty"` // files that could not be read
+
+	graphs      map[string]string // ProcessGraph name -> file
+	collections map[string]string // ActionVariablesCollection name -> file
+	paths       map[string]string // file name without extension, lower case -> file
+}
+
+// rootFiles are loaded by the game directly, so nothing refers to them.
+var rootFiles = []string{"museindex", "action_variables_def", "camera_group_def", "storycategories", "scene_files_*"}
+
+// ReadRefGraph reads all files under dir and resolves the references
+// between them: paths in scene_files_* (File filename), museindex
+// (package file), action_variables_def (actvarfile filename) and
+// camera_group_def (camgroupfile filename); package and PackageToSkip
+// naming ProcessGraphs; and actionvariablesdefname, ActionVariablesDefName
+// and ActionVariableSetName naming ActionVariablesCollections.
+//
+// Files that nothing refers to are orphans, unless they are loaded by the
+// game directly (rootFiles) or are index files themselves, i.e. refer to
+// other files by path.
+func ReadRefGraph(dir string) (*RefGraph, error) {
+	g := &RefGraph{
+		graphs:      make(map[string]string),
+		collections: make(map[string]string),
+		paths:       make(map[string]string),
+	}
+	err := g.readDir(dir, "")
+	if err != nil {
+		return nil, err
+	}
+	g.resolve()
+	return g, nil
+}
+
+func (g *RefGraph) readDir(dir string, rel string) error {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := path.Join(rel, entry.Name())
+		if entry.IsDir() {
+			err = g.readDir(path.Join(dir, entry.Name()), name)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if entry.Name() == data.ManifestName {
+			continue
+		}
+		err = g.readFile(path.Join(dir, entry.Name()), name)
+		if err != nil {
+			g.Errors = append(g.Errors, fmt.Sprintf("%s: %v", name, err))
+		}
+	}
+	return nil
+}
+
+func (g *RefGraph) readFile(filename string, name string) error {
+	bs, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	var doc *data.Document
+	switch data.DetectFileType(bs) {
+	case data.FileIBX1:
+		doc, err = data.ReadDocument(bufio.NewReader(bytes.NewReader(bs)), &data.Options{})
+	case data.FileXML:
+		doc, err = data.ReadXMLNamed(bytes.NewReader(bs), filename, &data.Options{})
+		if err == data.ErrFragment {
+			return nil
+		} else if err == data.ErrPlainXML {
+			g.addFile(name)
+			return g.scanPlainXML(bytes.NewReader(bs), name)
+		}
+	default:
+		return nil
+	}
+	g.addFile(name)
+	if err != nil {
+		return err
+	}
+	g.scanDocument(doc, name)
+	return nil
+}
+
+func (g *RefGraph) addFile(name string) {
+	g.Files = append(g.Files, name)
+	key := strings.ToLower(strings.TrimSuffix(name, path.Ext(name)))
+	if _, ok := g.paths[key]; !ok {
+		g.paths[key] = name
+	}
+}
+
+func (g *RefGraph) add(from string, kind string, source string, value string) {
+	if value == "" {
+		return
+	}
+	g.Refs = append(g.Refs, &Reference{From: from, Kind: kind, Source: source, Value: value})
+}
+
+// scanPlainXML collects the file and filename attributes of plain XML
+// files such as museindex.DAT.
+func (g *RefGraph) scanPlainXML(r io.Reader, name string) error {
+	dec := xml.NewDecoder(r)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if se, ok := tok.(xml.StartElement); ok {
+			for _, a := range se.Attr {
+				if a.Name.Local == "file" || a.Name.Local == "filename" {
+					g.add(name, RefFile, se.Name.Local+"."+a.Name.Local, a.Value)
+				}
+			}
+		}
+	}
+}
+
+func (g *RefGraph) scanDocument(doc *data.Document, name string) {
+	if doc.Element == nil {
+		return
+	}
+	doc.Element.FindFunc(func(n *data.Node) bool {
+		element := n.ElementName()
+		switch element {
+		case ProcessGraph:
+			g.define(g.graphs, StringProperty(n, "name"), name)
+		case ActionVariablesCollection:
+			g.define(g.collections, StringProperty(n, "ActionVariablesName"), name)
+		}
+		for _, p := range n.Properties {
+			pname := doc.Strings[p.Name]
+			source := ShortName(element) + "." + pname
+			switch {
+			case element == "File" && pname == "filename":
+				g.add(name, RefFile, source, StringProperty(n, pname))
+			case pname == "package" || pname == "PackageToSkip":
+				g.add(name, RefPackage, source, StringProperty(n, pname))
+			case strings.EqualFold(pname, "ActionVariablesDefName") || pname == "ActionVariableSetName":
+				g.add(name, RefActionVariables, source, StringProperty(n, pname))
+			}
+		}
+		return false
+	})
+}
+
+func (g *RefGraph) define(m map[string]string, key string, file string) {
+	if _, ok := m[key]; !ok && key != "" {
+		m[key] = file
+	}
+}
+
+// resolvePath finds the file a path reference points to. References are
+// Windows paths to .xml files, while the folder may hold .DAT files laid
+// out differently, so extensions are ignored and the longest matching
+// tail of the path wins.
+func (g *RefGraph) resolvePath(value string) string {
+	parts := strings.Split(strings.ToLower(strings.Replace(value, "\\", "/", -1)), "/")
+	last := parts[len(parts)-1]
+	parts[len(parts)-1] = strings.TrimSuffix(last, path.Ext(last))
+	for i := 0; i < len(parts); i++ {
+		if file, ok := g.paths[strings.Join(parts[i:], "/")]; ok {
+			return file
+		}
+	}
+	return ""
+}
+
+func (g *RefGraph) resolve() {
+	referenced := make(map[string]bool)
+	index := make(map[string]bool)
+	for _, r := range g.Refs {
+		switch r.Kind {
+		case RefFile:
+			r.To = g.resolvePath(r.Value)
+			index[r.From] = true
+		case RefPackage:
+			r.To = g.graphs[r.Value]
+		case RefActionVariables:
+			r.To = g.collections[r.Value]
+		}
+		if r.To != "" && r.To != r.From {
+			referenced[r.To] = true
+		}
+	}
+	sort.Strings(g.Files)
+	for _, f := range g.Files {
+		if !referenced[f] && !index[f] && !isRootFile(f) {
+			g.Orphans = append(g.Orphans, f)
+		}
+	}
+}
+
+func isRootFile(name string) bool {
+	base := strings.ToLower(path.Base(name))
+	base = strings.TrimSuffix(base, path.Ext(base))
+	for _, pattern := range rootFiles {
+		if ok, _ := path.Match(pattern, base); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Dangling returns the references that point to nothing in the folder.
+func (g *RefGraph) Dangling() []*Reference {
+	var refs []*Reference
+	for _, r := range g.Refs {
+		if r.To == "" {
+			refs = append(refs, r)
+		}
+	}
+	return refs
+}
+
+// WriteJSON writes the graph as JSON.
+func (g *RefGraph) WriteJSON(w io.Writer) error {
+	bs, err := json.MarshalIndent(g, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(bs, '\n'))
+	return err
+}
+
+// WriteDot writes the graph as a Graphviz digraph: one node per file,
+// one edge per distinct reference, orphans in grey and the targets of
+// dangling references in red.
+func (g *RefGraph) WriteDot(w io.Writer) error {
+	d := &dotWriter{w: w}
+	d.printf("digraph references {\n")
+	d.printf("  rankdir=LR;\n")
+	d.printf("  node [shape=box, fontname=\"Helvetica\"];\n")
+	d.printf("  edge [fontname=\"Helvetica\", fontsize=10];\n")
+	orphans := make(map[string]bool)
+	for _, f := range g.Orphans {
+		orphans[f] = true
+	}
+	ids := make(map[string]string)
+	for _, f := range g.Files {
+		attrs := ""
+		if orphans[f] {
+			attrs = ", style=filled, fillcolor=lightgrey"
+		}
+		ids[f] = d.node([]string{f}, attrs)
+	}
+	seen := make(map[string]bool)
+	for _, r := range g.Refs {
+		to := r.To
+		if to == "" {
+			to = "missing:" + r.Value
+			if ids[to] == "" {
+				ids[to] = d.node([]string{r.Value}, ", color=red, fontcolor=red")
+			}
+		}
+		key := r.From + "\x00" + to + "\x00" + r.Kind
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		attrs := ""
+		if r.To == "" {
+			attrs = ", color=red"
+		}
+		d.edge(ids[r.From], ids[to], r.Kind, attrs)
+	}
+	d.printf("}\n")
+	return d.err
+}
@@ -0,0 +1,482 @@
+package presentation
+
+import (
+	"fmt"
+	"juce/fifa-ibx1/data"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// More element names used by the simulator.
+const (
+	DecisionNodeRef = "DecisionTree.DecisionNodeRef"
+
+	ActionVariablesCollection = "ActionVariables.ActionVariablesCollection"
+	ActionVariableDef         = "ActionVariables.ActionVariableDef"
+	PresentationAction        = "Muse.PresentationAction"
+	CreateActionVariables     = "Muse.CreateActionVariablesAction"
+	SetActionVariable         = "Muse.SetActionVariableAction"
+)
+
+// Value is the value of a variable or expression during a simulation: a
+// number, or an enum value with its number (-1 if unknown).
+type Value struct {
+	Num  float64
+	Enum string
+}
+
+func (v Value) String() string {
+	if v.Enum != "" {
+		return v.Enum
+	}
+	return strconv.FormatFloat(v.Num, 'f', -1, 64)
+}
+
+// ParseValue reads a variable value as given on the command line: a
+// number, true or false, or an enum value, optionally as Type:Value.
+func ParseValue(s string) Value {
+	switch s {
+	case "true":
+		return Value{Num: 1}
+	case "false":
+		return Value{Num: 0}
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return Value{Num: f}
+	}
+	return Value{Num: -1, Enum: s[strings.LastIndex(s, ":")+1:]}
+}
+
+func boolValue(b bool) Value {
+	if b {
+		return Value{Num: 1}
+	}
+	return Value{Num: 0}
+}
+
+func (v Value) True() bool {
+	return v.Enum == "" && v.Num != 0
+}
+
+// Step is one line of a simulation trace.
+type Step struct {
+	Depth  int
+	Node   *data.Node // nil for notes
+	Text   string
+	Camera bool // a CameraNode that would run
+}
+
+// Simulator walks presentation graphs with a given set of variable
+// values and records which nodes would run. Variables are named as in
+// expressions: action variables by name, event variables as
+// @Event.Parameter and query variables as ?Table.Parameter.
+type Simulator struct {
+	Variables map[string]Value
+	Trace     []Step
+	// Unset lists the variables that were read without a value; they
+	// count as 0.
+	Unset []string
+
+	graphs      map[string]*data.Node
+	collections map[string]*data.Node
+	running     map[*data.Node]bool
+	depth       int
+}
+
+func NewSimulator() *Simulator {
+	return &Simulator{
+		Variables:   make(map[string]Value),
+		graphs:      make(map[string]*data.Node),
+		collections: make(map[string]*data.Node),
+		running:     make(map[*data.Node]bool),
+	}
+}
+
+// AddDocument makes the ProcessGraphs of doc available to
+// PresentationActions, and its ActionVariablesCollections to
+// CreateActionVariablesActions.
+func (s *Simulator) AddDocument(doc *data.Document) {
+	if doc.Element == nil {
+		return
+	}
+	for _, g := range doc.Element.Find(ProcessGraph) {
+		name := StringProperty(g, "name")
+		if _, ok := s.graphs[name]; !ok {
+			s.graphs[name] = g
+		}
+	}
+	for _, c := range doc.Element.Find(ActionVariablesCollection) {
+		s.collections[StringProperty(c, "ActionVariablesName")] = c
+	}
+}
+
+// Graph returns the loaded ProcessGraph with the given name, or nil.
+func (s *Simulator) Graph(name string) *data.Node {
+	return s.graphs[name]
+}
+
+func (s *Simulator) step(n *data.Node, format string, args ...interface{}) {
+	s.Trace = append(s.Trace, Step{
+		Depth:  s.depth,
+		Node:   n,
+		Text:   fmt.Sprintf(format, args...),
+		Camera: n != nil && n.ElementName() == CameraNode,
+	})
+}
+
+// Run simulates the ProcessGraph g and appends to the trace. Graphs and
+// decision nodes that are already running are not entered again.
+func (s *Simulator) Run(g *data.Node) {
+	if s.running[g] {
+		s.step(nil, "(already running %s)", StringProperty(g, "name"))
+		return
+	}
+	s.running[g] = true
+	defer delete(s.running, g)
+
+	s.step(g, "ProcessGraph %s", StringProperty(g, "name"))
+	s.depth++
+	defer func() { s.depth-- }()
+	if def := StringProperty(g, "actionvariablesdefname"); def != "" {
+		s.createVariables(def)
+	}
+	for _, c := range flowChildren(g) {
+		s.visit(c, g)
+	}
+}
+
+// flowChildren returns the flow nodes below n, looking into ChildrenList.
+func flowChildren(n *data.Node) []*data.Node {
+	var flow []*data.Node
+	for _, c := range n.Children {
+		name := c.ElementName()
+		if name == ChildrenList {
+			flow = append(flow, flowChildren(c)...)
+		} else if IsFlowNode(name) && name != Selector {
+			flow = append(flow, c)
+		}
+	}
+	return flow
+}
+
+func label(n *data.Node) string {
+	s := ShortName(n.ElementName())
+	if name := StringProperty(n, "name"); name != "" {
+		s += " " + name
+	}
+	return s
+}
+
+func (s *Simulator) visit(n *data.Node, graph *data.Node) {
+	switch n.ElementName() {
+	case SwitchCaseNode:
+		s.switchCase(n, graph)
+	case DecisionNode:
+		s.decision(n, graph)
+	case WaitNode:
+		s.wait(n)
+	case DecisionNodeRef:
+		target := findDecisionNode(graph, StringProperty(n, "name"))
+		if target == nil {
+			s.step(n, "%s: no DecisionNode with that name", label(n))
+			return
+		}
+		s.step(n, "%s", label(n))
+		s.depth++
+		s.decision(target, graph)
+		s.depth--
+	case CameraNode:
+		s.step(n, "%s [%s]", label(n), strings.Join(CameraNames(n), ", "))
+		s.children(n, graph)
+	default:
+		s.step(n, "%s", label(n))
+		s.children(n, graph)
+	}
+}
+
+func (s *Simulator) children(n *data.Node, graph *data.Node) {
+	s.depth++
+	for _, c := range flowChildren(n) {
+		s.visit(c, graph)
+	}
+	s.depth--
+}
+
+func findDecisionNode(graph *data.Node, name string) *data.Node {
+	found := graph.FindFunc(func(n *data.Node) bool {
+		return n.ElementName() == DecisionNode && StringProperty(n, "name") == name
+	})
+	if len(found) == 0 {
+		return nil
+	}
+	return found[0]
+}
+
+// switchCase runs the first CaseNode whose caseExpression equals the
+// switchExpression, or else the first one without a caseExpression.
+func (s *Simulator) switchCase(n *data.Node, graph *data.Node) {
+	value := s.eval(Expression(n, "switchExpression"))
+	s.step(n, "%s: switch %s = %v", label(n), expressionLabel(Expression(n, "switchExpression")), value)
+	var chosen, fallback *data.Node
+	for _, c := range flowChildren(n) {
+		if c.ElementName() != CaseNode {
+			continue
+		}
+		expr := Expression(c, "caseExpression")
+		if expr == nil {
+			if fallback == nil {
+				fallback = c
+			}
+		} else if chosen == nil && equal(value, s.eval(expr)) {
+			chosen = c
+		}
+	}
+	if chosen == nil {
+		chosen = fallback
+	}
+	s.depth++
+	defer func() { s.depth-- }()
+	if chosen == nil {
+		s.step(nil, "(no case matches)")
+		return
+	}
+	s.step(chosen, "%s: %s", label(chosen), caseLabel(chosen))
+	s.children(chosen, graph)
+}
+
+// decision runs a DecisionNode whose selector holds: its actions, then
+// the child DecisionNode with the highest priority among those whose
+// selectors hold, then its WaitNodes.
+func (s *Simulator) decision(n *data.Node, graph *data.Node) {
+	if s.running[n] {
+		// a DecisionNodeRef back to an ancestor
+		s.step(n, "%s: (already running)", label(n))
+		return
+	}
+	s.running[n] = true
+	defer delete(s.running, n)
+	ok, text := s.selector(n)
+	if !ok {
+		s.step(n, "%s: %s -> false", label(n), text)
+		return
+	}
+	s.step(n, "%s: %s -> true", label(n), text)
+	s.depth++
+	defer func() { s.depth-- }()
+	s.actions(n)
+
+	var candidates []*data.Node
+	for _, c := range flowChildren(n) {
+		switch c.ElementName() {
+		case DecisionNode, DecisionNodeRef:
+			candidates = append(candidates, c)
+		}
+	}
+	var best *data.Node
+	bestPriority := 0
+	for _, c := range candidates {
+		target := c
+		if c.ElementName() == DecisionNodeRef {
+			target = findDecisionNode(graph, StringProperty(c, "name"))
+			if target == nil {
+				continue
+			}
+		}
+		ok, _ := s.selector(target)
+		priority := s.priority(c)
+		if ok && (best == nil || priority > bestPriority) {
+			best, bestPriority = c, priority
+		}
+	}
+	if best != nil {
+		s.visit(best, graph)
+	}
+	for _, c := range flowChildren(n) {
+		if c.ElementName() == WaitNode {
+			s.visit(c, graph)
+		}
+	}
+}
+
+func (s *Simulator) priority(n *data.Node) int {
+	v, _ := strconv.Atoi(StringProperty(n, "priority"))
+	return v
+}
+
+// wait shows whether the condition a WaitNode waits for already holds;
+// if so, its actions run.
+func (s *Simulator) wait(n *data.Node) {
+	ok, text := s.selector(n)
+	if !ok {
+		s.step(n, "%s: waiting for %s", label(n), text)
+		return
+	}
+	s.step(n, "%s: %s -> done", label(n), text)
+	s.depth++
+	s.actions(n)
+	s.depth--
+}
+
+// selector evaluates the Selector of n. Nodes without one always run.
+func (s *Simulator) selector(n *data.Node) (bool, string) {
+	sel := Child(n, Selector)
+	if sel == nil || len(sel.Children) == 0 {
+		return true, "(no selector)"
+	}
+	return s.eval(sel.Children[0]).True(), expressionLabel(sel.Children[0])
+}
+
+func (s *Simulator) actions(n *data.Node) {
+	ac := Child(n, ActionCollection)
+	if ac == nil {
+		return
+	}
+	for _, a := range ac.Children {
+		switch a.ElementName() {
+		case CreateActionVariables:
+			name := StringProperty(a, "ActionVariablesDefName")
+			s.step(a, "create action variables %s", name)
+			s.createVariables(name)
+		case SetActionVariable:
+			name := StringProperty(a, "ActionVariableName")
+			var v Value
+			if len(a.Children) > 0 {
+				v = s.eval(a.Children[0])
+			}
+			s.Variables[name] = v
+			s.step(a, "set %s = %v", name, v)
+		case PresentationAction:
+			name := StringProperty(a, "package")
+			s.step(a, "play package %s", name)
+			if g := s.graphs[name]; g != nil {
+				s.depth++
+				s.Run(g)
+				s.depth--
+			} else {
+				s.depth++
+				s.step(nil, "(package %s is not loaded)", name)
+				s.depth--
+			}
+		default:
+			s.step(a, "%s", strings.Join(actionLabel(a), " "))
+		}
+	}
+}
+
+// createVariables gives the variables of an ActionVariablesCollection
+// their default values, unless they already have one.
+func (s *Simulator) createVariables(name string) {
+	c := s.collections[name]
+	if c == nil {
+		return
+	}
+	for _, def := range c.Children {
+		if def.ElementName() != ActionVariableDef || len(def.Children) == 0 {
+			continue
+		}
+		vname := StringProperty(def, "ActionVariableName")
+		if _, ok := s.Variables[vname]; !ok {
+			s.Variables[vname] = s.eval(def.Children[0])
+		}
+	}
+}
+
+// variable returns the value of a variable, recording it as unset if it
+// has none.
+func (s *Simulator) variable(name string) Value {
+	v, ok := s.Variables[name]
+	if !ok {
+		found := false
+		for _, u := range s.Unset {
+			found = found || u == name
+		}
+		if !found {
+			s.Unset = append(s.Unset, name)
+			sort.Strings(s.Unset)
+		}
+	}
+	return v
+}
+
+func (s *Simulator) eval(n *data.Node) Value {
+	if n == nil {
+		return Value{}
+	}
+	switch n.ElementName() {
+	case Logic:
+		op, _ := operator(n, logicOperators)
+		for _, c := range n.Children {
+			v := s.eval(c).True()
+			if op == 0 && !v {
+				return boolValue(false)
+			}
+			if op == 1 && v {
+				return boolValue(true)
+			}
+		}
+		return boolValue(op == 0)
+	case Comparison:
+		if len(n.Children) != 2 {
+			return boolValue(false)
+		}
+		op, _ := operator(n, comparisonOperators)
+		return boolValue(compare(s.eval(n.Children[0]), s.eval(n.Children[1]), op))
+	case ActionVariable:
+		return s.variable(StringProperty(n, "VariableName"))
+	case EventVariable:
+		return s.variable("@" + StringProperty(n, "Event") + "." + StringProperty(n, "Parameter"))
+	case QueryVariable:
+		return s.variable("?" + StringProperty(n, "Table") + "." + StringProperty(n, "Parameter"))
+	case Variable:
+		if len(n.Properties) == 0 {
+			return Value{}
+		}
+		name := n.Document().Strings[n.Properties[0].Name]
+		switch v := n.Value(name).(type) {
+		case data.Float:
+			return Value{Num: float64(v.Value)}
+		case data.Bool:
+			return boolValue(v.Value)
+		case data.String:
+			return ParseValue(StringProperty(n, name))
+		}
+		num, _ := strconv.ParseFloat(StringProperty(n, name), 64)
+		return Value{Num: num, Enum: StringProperty(n, "enumValue")}
+	}
+	return Value{}
+}
+
+func equal(a Value, b Value) bool {
+	if a.Enum != "" && b.Enum != "" {
+		return a.Enum == b.Enum
+	}
+	if a.Enum != "" || b.Enum != "" {
+		// an enum value against a number: compare the enum's number, if known
+		return a.Num == b.Num && a.Num != -1
+	}
+	return a.Num == b.Num
+}
+
+func compare(a Value, b Value, op int) bool {
+	switch op {
+	case 0:
+		return equal(a, b)
+	case 1:
+		return !equal(a, b)
+	}
+	if a.Enum != "" || b.Enum != "" {
+		return false
+	}
+	switch op {
+	case 2:
+		return a.Num < b.Num
+	case 3:
+		return a.Num <= b.Num
+	case 4:
+		return a.Num > b.Num
+	case 5:
+		return a.Num >= b.Num
+	}
+	return false
+}
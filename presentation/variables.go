@@ -0,0 +1,350 @@
+package presentation
+
+import (
+	"fmt"
+	"juce/fifa-ibx1/data"
+	"sort"
+)
+
+// VariableDef is an action variable defined by an ActionVariableDef.
+// Type is the kind of its default value: int, float, bool, string or
+// "enum <EnumType>".
+type VariableDef struct {
+	Name string
+	Set  string
+	Type string
+	File string
+	Uses int
+}
+
+// EventUse counts how an event or query variable is used. Its type comes
+// from the Type property of each use.
+type EventUse struct {
+	Name  string
+	Types map[string]int
+	Uses  int
+}
+
+// Catalogue collects the variables of a folder: action variables from the
+// ActionVariablesCollections, and the event and query variables the
+// expressions use. Add every document first, then Check each of them.
+type Catalogue struct {
+	Defs   map[string][]*VariableDef
+	Events map[string]*EventUse
+
+	// kinds of the literal values of each FIFAExpressionProperty,
+	// to tell what a property expects
+	propertyKinds map[string]map[string]int
+}
+
+func NewCatalogue() *Catalogue {
+	return &Catalogue{
+		Defs:          make(map[string][]*VariableDef),
+		Events:        make(map[string]*EventUse),
+		propertyKinds: make(map[string]map[string]int),
+	}
+}
+
+// literalKind returns the type of a literal ExpressionTree.Variable, which
+// is the name of its first property.
+func literalKind(n *data.Node) string {
+	if n.Property("enumType") != nil {
+		return "enum " + StringProperty(n, "enumType")
+	}
+	if len(n.Properties) == 0 {
+		return ""
+	}
+	return n.Document().Strings[n.Properties[0].Name]
+}
+
+// compatible reports whether values of the two kinds can be compared.
+// Enums are ints, so they can be compared with plain ints.
+func compatible(a string, b string) bool {
+	if a == "" || b == "" || a == b {
+		return true
+	}
+	isInt := func(k string) bool { return k == "int" || len(k) > 5 && k[:5] == "enum " }
+	return (a == "int" && isInt(b)) || (b == "int" && isInt(a))
+}
+
+// AddDocument adds the variable definitions of a document, and learns
+// the types FIFAExpressionProperties are given.
+func (c *Catalogue) AddDocument(file string, doc *data.Document) {
+	if doc.Element == nil {
+		return
+	}
+	doc.Element.FindFunc(func(n *data.Node) bool {
+		switch n.ElementName() {
+		case ActionVariableDef:
+			def := &VariableDef{
+				Name: StringProperty(n, "ActionVariableName"),
+				Set:  StringProperty(n, "ActionVariableSetName"),
+				File: file,
+			}
+			if len(n.Children) > 0 && n.Children[0].ElementName() == Variable {
+				def.Type = literalKind(n.Children[0])
+			}
+			c.Defs[def.Name] = append(c.Defs[def.Name], def)
+		case ExpressionProperty:
+			if len(n.Children) > 0 && n.Children[0].ElementName() == Variable {
+				name := StringProperty(n, "PropertyName")
+				if c.propertyKinds[name] == nil {
+					c.propertyKinds[name] = make(map[string]int)
+				}
+				c.propertyKinds[name][literalKind(n.Children[0])]++
+			}
+		}
+		return false
+	})
+}
+
+// Sorted returns the action variable definitions ordered by name.
+func (c *Catalogue) Sorted() []*VariableDef {
+	var defs []*VariableDef
+	for _, list := range c.Defs {
+		defs = append(defs, list...)
+	}
+	sort.Slice(defs, func(i, j int) bool {
+		if defs[i].Name != defs[j].Name {
+			return defs[i].Name < defs[j].Name
+		}
+		return defs[i].File < defs[j].File
+	})
+	return defs
+}
+
+// SortedEvents returns the event and query variables ordered by name.
+func (c *Catalogue) SortedEvents() []*EventUse {
+	var uses []*EventUse
+	for _, u := range c.Events {
+		uses = append(uses, u)
+	}
+	sort.Slice(uses, func(i, j int) bool { return uses[i].Name < uses[j].Name })
+	return uses
+}
+
+// Unused returns the action variables that no checked document uses.
+func (c *Catalogue) Unused() []*VariableDef {
+	var unused []*VariableDef
+	for _, def := range c.Sorted() {
+		if def.Uses == 0 {
+			unused = append(unused, def)
+		}
+	}
+	return unused
+}
+
+// Conflicts reports action variables defined more than once with
+// different types, and event variables used with different types.
+func (c *Catalogue) Conflicts() []data.Finding {
+	var findings []data.Finding
+	for _, def := range c.Sorted() {
+		first := c.Defs[def.Name][0]
+		if def != first && def.Type != first.Type {
+			findings = append(findings, data.Finding{Severity: data.SeverityWarning, Offset: -1, Message: fmt.Sprintf("action variable %s is %s in %s (%s) but %s in %s (%s)",
+				def.Name, first.Type, first.Set, first.File, def.Type, def.Set, def.File)})
+		}
+	}
+	for _, u := range c.SortedEvents() {
+		if len(u.Types) > 1 {
+			var types []string
+			for t := range u.Types {
+				types = append(types, t)
+			}
+			sort.Strings(types)
+			findings = append(findings, data.Finding{Severity: data.SeverityWarning, Offset: -1, Message: fmt.Sprintf("variable %s is used with types %v", u.Name, types)})
+		}
+	}
+	return findings
+}
+
+// lookup finds the definition of an action variable, counting the use.
+func (c *Catalogue) lookup(name string) *VariableDef {
+	defs := c.Defs[name]
+	if len(defs) == 0 {
+		return nil
+	}
+	for _, def := range defs {
+		def.Uses++
+	}
+	return defs[0]
+}
+
+// suggest returns a defined variable whose name is close to name.
+func (c *Catalogue) suggest(name string) string {
+	best, bestDist := "", 3
+	for _, def := range c.Sorted() {
+		if d := editDistance(name, def.Name); d < bestDist {
+			best, bestDist = def.Name, d
+		}
+	}
+	return best
+}
+
+func editDistance(a string, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+func min3(a int, b int, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// operandKind returns the type of an expression operand, looking up action
+// variables and recording event variables. An empty kind means unknown.
+func (c *Catalogue) operandKind(n *data.Node, add func(data.Severity, *data.Node, string, ...interface{})) string {
+	switch n.ElementName() {
+	case Logic, Comparison:
+		return "bool"
+	case Variable:
+		return literalKind(n)
+	case ActionVariable:
+		name := StringProperty(n, "VariableName")
+		def := c.lookup(name)
+		if def == nil {
+			msg := "undefined action variable %s"
+			if s := c.suggest(name); s != "" {
+				msg += fmt.Sprintf(" (did you mean %s?)", s)
+			}
+			add(data.SeverityError, n, msg, name)
+			return ""
+		}
+		return def.Type
+	case EventVariable, QueryVariable:
+		name, _ := formatOperand(n)
+		if i := len(name) - len(typeSuffix(StringProperty(n, "Type"))); i > 0 {
+			name = name[:i]
+		}
+		u := c.Events[name]
+		if u == nil {
+			u = &EventUse{Name: name, Types: make(map[string]int)}
+			c.Events[name] = u
+		}
+		typ := StringProperty(n, "Type")
+		u.Types[typ]++
+		u.Uses++
+		return typ
+	}
+	return ""
+}
+
+// Check reports undefined action variables and type mismatches in
+// comparisons, switch/case nodes, SetActionVariableActions and
+// FIFAExpressionProperties, and counts the uses of each variable.
+func (c *Catalogue) Check(doc *data.Document) []data.Finding {
+	var findings []data.Finding
+	add := func(s data.Severity, n *data.Node, format string, args ...interface{}) {
+		findings = append(findings, data.Finding{Severity: s, Offset: n.Offset, Message: fmt.Sprintf(format, args...)})
+	}
+	if doc.Element == nil {
+		return nil
+	}
+	var walk func(n *data.Node)
+	walk = func(n *data.Node) {
+		switch n.ElementName() {
+		case Comparison:
+			if len(n.Children) == 2 {
+				a := c.operandKind(n.Children[0], add)
+				b := c.operandKind(n.Children[1], add)
+				if !compatible(a, b) {
+					add(data.SeverityError, n, "comparing %s with %s: %s", a, b, expressionLabel(n))
+				}
+				// nested expressions; the variables have been looked at
+				for _, op := range n.Children {
+					if e := op.ElementName(); e == Logic || e == Comparison {
+						walk(op)
+					}
+				}
+				return
+			}
+		case ActionVariable, EventVariable, QueryVariable:
+			c.operandKind(n, add)
+		case SwitchCaseNode:
+			if expr := Expression(n, "switchExpression"); expr != nil {
+				kind := c.operandKind(expr, add)
+				for _, cn := range flowChildren(n) {
+					cexpr := Expression(cn, "caseExpression")
+					if cn.ElementName() != CaseNode || cexpr == nil {
+						continue
+					}
+					if ck := literalKind(cexpr); cexpr.ElementName() == Variable && !compatible(kind, ck) {
+						add(data.SeverityError, cn, "case %s is %s, but %s is %s",
+							expressionLabel(cexpr), ck, expressionLabel(expr), kind)
+					}
+				}
+				// the switch expression has been looked at
+				for _, child := range n.Children {
+					if child.ElementName() != ExpressionProperty || StringProperty(child, "PropertyName") != "switchExpression" {
+						walk(child)
+					}
+				}
+				return
+			}
+		case SetActionVariable:
+			name := StringProperty(n, "ActionVariableName")
+			def := c.lookup(name)
+			if def == nil {
+				add(data.SeverityError, n, "setting undefined action variable %s", name)
+			} else {
+				if set := StringProperty(n, "ActionVariableSetName"); set != def.Set {
+					add(data.SeverityWarning, n, "action variable %s belongs to %s, not %s", name, def.Set, set)
+				}
+				if len(n.Children) > 0 {
+					if kind := c.operandKind(n.Children[0], add); !compatible(def.Type, kind) {
+						add(data.SeverityError, n, "setting %s variable %s to %s value %s",
+							def.Type, name, kind, expressionLabel(n.Children[0]))
+					}
+				}
+			}
+			return
+		case ExpressionProperty:
+			if len(n.Children) > 0 && n.Children[0].ElementName() == ActionVariable {
+				prop := StringProperty(n, "PropertyName")
+				kind := c.operandKind(n.Children[0], add)
+				if expected := c.expectedKind(prop); !compatible(kind, expected) {
+					add(data.SeverityWarning, n, "%s is usually %s, but variable %s is %s",
+						prop, expected, StringProperty(n.Children[0], "VariableName"), kind)
+				}
+				return
+			}
+		}
+		for _, child := range n.Children {
+			walk(child)
+		}
+	}
+	walk(doc.Element)
+	return findings
+}
+
+// expectedKind returns the kind most literal values of a
+// FIFAExpressionProperty have, or "" if it is never given a literal.
+func (c *Catalogue) expectedKind(prop string) string {
+	best, bestCount := "", 0
+	for kind, count := range c.propertyKinds[prop] {
+		if count > bestCount || count == bestCount && kind < best {
+			best, bestCount = kind, count
+		}
+	}
+	return best
+}